@@ -0,0 +1,705 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"path"
+	"strconv"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	"github.com/alucod3/gorrent/internal/cli"
+	"github.com/alucod3/gorrent/internal/config"
+	"github.com/alucod3/gorrent/internal/daemon"
+	"github.com/alucod3/gorrent/internal/downloader"
+	"github.com/alucod3/gorrent/internal/hooks"
+	"github.com/alucod3/gorrent/internal/validator"
+	"github.com/alucod3/gorrent/internal/watcher"
+	"github.com/alucod3/gorrent/pkg/utils"
+)
+
+// commandFunc runs a gorrent subcommand with the remaining arguments
+type commandFunc func(ui *cli.UI, cfg *config.Config, args []string) error
+
+// commands maps subcommand names to their implementation
+var commands = map[string]commandFunc{
+	"daemon":   runDaemon,
+	"watch":    runWatch,
+	"list":     runList,
+	"stats":    runStats,
+	"info":     runInfo,
+	"pause":    runPause,
+	"resume":   runResume,
+	"remove":   runRemove,
+	"files":    runFiles,
+	"priority": runPriority,
+	"stream":   runStream,
+	"peers":    runPeers,
+	"trackers": runTrackers,
+	"history":  runHistory,
+}
+
+// runDaemon keeps a long-lived torrent client and serves the control API
+func runDaemon(ui *cli.UI, cfg *config.Config, args []string) error {
+	flags := newFlagSet("daemon")
+	flags.BoolVar(&cfg.WebUI, "web", cfg.WebUI, "serve the web interface")
+	addNetworkFlags(flags, cfg)
+	if args, err := parseFlags(flags, args); err != nil || len(args) != 0 {
+		return errShowUsage
+	}
+	return serveDaemon(ui, cfg)
+}
+
+// runWatch runs the daemon watching the given directories for .torrent files
+func runWatch(ui *cli.UI, cfg *config.Config, args []string) error {
+	flags := newFlagSet("watch")
+	flags.BoolVar(&cfg.WebUI, "web", cfg.WebUI, "serve the web interface")
+	addNetworkFlags(flags, cfg)
+	dirs, err := parseFlags(flags, args)
+	if err != nil {
+		return errShowUsage
+	}
+
+	cfg.WatchDirs = append(cfg.WatchDirs, dirs...)
+	if len(cfg.WatchDirs) == 0 {
+		return fmt.Errorf("no watch directories given or configured in %s", cfg.FilePath())
+	}
+	return serveDaemon(ui, cfg)
+}
+
+// serveDaemon runs the manager, the control API and the folder watcher until interrupted
+func serveDaemon(ui *cli.UI, cfg *config.Config) error {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	logger := log.New(os.Stderr, "", log.LstdFlags)
+	manager, err := downloader.NewManager(cfg, hooks.NewRunner(cfg, logger))
+	if err != nil {
+		return err
+	}
+	defer manager.Close()
+
+	session := manager.Stats().Session
+	if session.Restored > 0 {
+		ui.ShowInfo(fmt.Sprintf("Restored %d torrents from the previous session", session.Restored))
+	}
+	if session.Quarantined > 0 {
+		ui.ShowWarning(fmt.Sprintf("%d session entries could not be restored and were moved to %s",
+			session.Quarantined, cfg.SessionQuarantinePath()))
+	}
+	if session.Error != "" {
+		ui.ShowWarning("Session: " + session.Error)
+	}
+
+	ui.ShowInfo(fmt.Sprintf("Daemon listening on http://%s", cfg.DaemonAddress))
+	if cfg.WebUI {
+		ui.ShowInfo(fmt.Sprintf("Web interface available at http://%s/", cfg.DaemonAddress))
+	}
+
+	if len(cfg.WatchDirs) > 0 {
+		w := watcher.New(cfg, func(path string) error {
+			_, err := manager.Add(path, downloader.Options{})
+			return err
+		}, logger)
+		go func() {
+			if err := w.Run(ctx); err != nil {
+				ui.ShowError("Error watching folders", err)
+			}
+		}()
+		ui.ShowInfo(fmt.Sprintf("Watching %s", strings.Join(cfg.WatchDirs, ", ")))
+	}
+	if err := daemon.NewServer(cfg, manager).Run(ctx); err != nil {
+		return err
+	}
+
+	ui.ShowWarning("Daemon stopped")
+	return nil
+}
+
+// runStream serves a file of a torrent over HTTP while it downloads
+func runStream(ui *cli.UI, cfg *config.Config, args []string) error {
+	var index *int
+	flags := newFlagSet("stream")
+	flags.StringVar(&cfg.StreamAddress, "addr", cfg.StreamAddress, "address to serve the file on")
+	flags.StringVar(&cfg.Storage, "storage", cfg.Storage, "storage backend, e.g. memory")
+	addNetworkFlags(flags, cfg)
+	flags.Func("file", "index of the file to serve", func(s string) error {
+		i, err := strconv.Atoi(s)
+		index = &i
+		return err
+	})
+	args, err := parseFlags(flags, args)
+	if err != nil || len(args) != 1 {
+		return errShowUsage
+	}
+	link := args[0]
+
+	v, err := validator.New()
+	if err != nil {
+		return err
+	}
+	if err := v.IsValidTorrentLink(link); err != nil {
+		return err
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	streamer, err := downloader.NewStreamer(cfg)
+	if err != nil {
+		return err
+	}
+	defer streamer.Close()
+
+	ui.ShowInfo("Waiting for torrent metadata...")
+	file, err := streamer.Open(ctx, link, index)
+	if err != nil {
+		if errors.Is(err, context.Canceled) {
+			return nil
+		}
+		return err
+	}
+
+	listener, err := net.Listen("tcp", cfg.StreamAddress)
+	if err != nil {
+		return err
+	}
+	server := &http.Server{
+		Handler:           streamer,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	go func() {
+		<-ctx.Done()
+		server.Close()
+	}()
+
+	ui.ShowSuccess(fmt.Sprintf("Streaming %s (%s)", file.DisplayPath(), utils.BytesToString(file.Length())))
+	ui.ShowInfo(fmt.Sprintf("Open http://%s/%s in a media player or with curl", cfg.StreamAddress, url.PathEscape(path.Base(file.DisplayPath()))))
+	if err := server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+
+	ui.ShowWarning("Streaming stopped")
+	return nil
+}
+
+// runList prints the torrents managed by the daemon
+func runList(ui *cli.UI, cfg *config.Config, args []string) error {
+	if len(args) != 0 {
+		return errShowUsage
+	}
+
+	list, err := daemon.NewClient(cfg.DaemonAddress).List()
+	if err != nil {
+		return err
+	}
+	if len(list) == 0 {
+		ui.ShowInfo("No torrents in the daemon")
+		return nil
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tNAME\tCATEGORY\tSTATE\tPROGRESS\tSIZE\tPEERS\tDOWN\tUP")
+	for _, st := range list {
+		name := st.Name
+		if st.Error != "" {
+			name += " (" + st.Error + ")"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%.1f%%\t%s\t%d\t%s/s\t%s/s\n",
+			st.ID[:8],
+			name,
+			orDash(st.Category),
+			st.State,
+			st.Progress,
+			utils.BytesToString(st.Size),
+			st.Peers,
+			utils.BytesToString(int64(st.DownloadSpeed)),
+			utils.BytesToString(int64(st.UploadSpeed)))
+	}
+	return w.Flush()
+}
+
+// runStats prints the daemon totals and the blocklist counters
+func runStats(ui *cli.UI, cfg *config.Config, args []string) error {
+	if len(args) != 0 {
+		return errShowUsage
+	}
+
+	stats, err := daemon.NewClient(cfg.DaemonAddress).Stats()
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "Torrents:\t%d\n", stats.Torrents)
+	fmt.Fprintf(w, "Peers:\t%d\n", stats.Peers)
+	fmt.Fprintf(w, "Down:\t%s/s\n", utils.BytesToString(int64(stats.DownloadSpeed)))
+	fmt.Fprintf(w, "Up:\t%s/s\n", utils.BytesToString(int64(stats.UploadSpeed)))
+	if s := stats.Session; s.Restored > 0 || s.Quarantined > 0 {
+		fmt.Fprintf(w, "Session:\t%d torrents restored, %d entries quarantined\n", s.Restored, s.Quarantined)
+	}
+	if s := stats.Session; s.Error != "" {
+		fmt.Fprintf(w, "Session error:\t%s\n", s.Error)
+	}
+	if bl := stats.Blocklist; bl != nil {
+		fmt.Fprintf(w, "Blocklist:\t%d ranges from %d lists, updated %s\n",
+			bl.Ranges, bl.Sources, bl.Updated.Format(time.DateTime))
+		fmt.Fprintf(w, "Refused:\t%d blocked peer addresses and connections\n", bl.Refused)
+		if bl.Skipped > 0 {
+			fmt.Fprintf(w, "Skipped:\t%d malformed blocklist lines\n", bl.Skipped)
+		}
+		if bl.Error != "" {
+			fmt.Fprintf(w, "Blocklist error:\t%s\n", bl.Error)
+		}
+	}
+	for _, m := range stats.PortMappings {
+		method := strings.TrimSpace(m.Method + " " + m.Protocol)
+		if m.Error != "" {
+			fmt.Fprintf(w, "Port mapping:\t%s failed: %s\n", method, m.Error)
+			continue
+		}
+		external := strconv.Itoa(m.ExternalPort)
+		if m.ExternalIP != "" {
+			external = net.JoinHostPort(m.ExternalIP, external)
+		}
+		fmt.Fprintf(w, "Port mapping:\t%s port %d mapped to %s on %s\n", method, m.InternalPort, external, m.Gateway)
+	}
+	return w.Flush()
+}
+
+// runInfo describes a .torrent file or magnet link, or a torrent in the daemon
+func runInfo(ui *cli.UI, cfg *config.Config, args []string) error {
+	if len(args) != 1 {
+		return errShowUsage
+	}
+	if !utils.FileExists(args[0]) && !strings.HasPrefix(args[0], "magnet:") {
+		return runDaemonInfo(cfg, args[0])
+	}
+
+	info, err := downloader.LoadInfo(args[0])
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "Name:\t%s\n", orDash(info.Name))
+	if info.InfoHash != "" {
+		fmt.Fprintf(w, "Info hash v1:\t%s\n", info.InfoHash)
+	}
+	if info.InfoHashV2 != "" {
+		fmt.Fprintf(w, "Info hash v2:\t%s\n", info.InfoHashV2)
+	}
+	if info.HasInfo {
+		fmt.Fprintf(w, "Version:\t%s\n", versionLabel(info.Version))
+		fmt.Fprintf(w, "Size:\t%s\n", utils.BytesToString(info.Size))
+		fmt.Fprintf(w, "Files:\t%d\n", info.Files)
+		fmt.Fprintf(w, "Pieces:\t%d x %s\n", info.Pieces, utils.BytesToString(info.PieceLength))
+		fmt.Fprintf(w, "Private:\t%s\n", privateLabel(info.Private))
+		if info.CreatedBy != "" {
+			fmt.Fprintf(w, "Created by:\t%s\n", info.CreatedBy)
+		}
+		if !info.CreationDate.IsZero() {
+			fmt.Fprintf(w, "Created:\t%s\n", info.CreationDate.Format(time.DateTime))
+		}
+		if info.Comment != "" {
+			fmt.Fprintf(w, "Comment:\t%s\n", info.Comment)
+		}
+	} else {
+		fmt.Fprintf(w, "Private:\tunknown until the metadata is downloaded\n")
+	}
+	for tier, urls := range info.Trackers {
+		for _, u := range urls {
+			fmt.Fprintf(w, "Tracker (tier %d):\t%s\n", tier, u)
+		}
+	}
+	for _, u := range info.WebSeeds {
+		fmt.Fprintf(w, "Web seed:\t%s\n", u)
+	}
+	return w.Flush()
+}
+
+// runDaemonInfo describes a torrent in the daemon
+func runDaemonInfo(cfg *config.Config, id string) error {
+	status, err := daemon.NewClient(cfg.DaemonAddress).Get(id)
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "Name:\t%s\n", orDash(status.Name))
+	switch status.Version {
+	case "":
+		fmt.Fprintf(w, "Info hash:\t%s\n", status.ID)
+	case downloader.VersionV2:
+		fmt.Fprintf(w, "Info hash v2:\t%s\n", status.InfoHashV2)
+	default:
+		fmt.Fprintf(w, "Info hash v1:\t%s\n", status.ID)
+		if status.InfoHashV2 != "" {
+			fmt.Fprintf(w, "Info hash v2:\t%s\n", status.InfoHashV2)
+		}
+	}
+	if status.Version != "" {
+		fmt.Fprintf(w, "Version:\t%s\n", versionLabel(status.Version))
+	}
+	fmt.Fprintf(w, "State:\t%s\n", status.State)
+	// Os arquivos só são conhecidos depois que os metadados chegam
+	if len(status.Files) > 0 {
+		fmt.Fprintf(w, "Size:\t%s\n", utils.BytesToString(status.Size))
+		fmt.Fprintf(w, "Files:\t%d\n", len(status.Files))
+		fmt.Fprintf(w, "Private:\t%s\n", privateLabel(status.Private))
+	}
+	return w.Flush()
+}
+
+// versionLabel describes the protocol version of a torrent
+func versionLabel(version string) string {
+	switch version {
+	case downloader.VersionV2:
+		return "v2 (pieces verified against per-file merkle roots)"
+	case downloader.VersionHybrid:
+		return "hybrid (joins both the v1 and v2 swarms)"
+	}
+	return version
+}
+
+// privateLabel describes the private flag of a torrent
+func privateLabel(private bool) string {
+	if private {
+		return "yes (trackers only: no DHT, PEX, local discovery or extra trackers)"
+	}
+	return "no"
+}
+
+// orDash returns s, or "-" when it is empty
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
+
+// runPause pauses a torrent in the daemon
+func runPause(ui *cli.UI, cfg *config.Config, args []string) error {
+	return runTorrentAction(ui, args, daemon.NewClient(cfg.DaemonAddress).Pause, "Torrent paused")
+}
+
+// runResume resumes a paused torrent in the daemon
+func runResume(ui *cli.UI, cfg *config.Config, args []string) error {
+	return runTorrentAction(ui, args, daemon.NewClient(cfg.DaemonAddress).Resume, "Torrent resumed")
+}
+
+// runRemove removes a torrent from the daemon, keeping its data
+func runRemove(ui *cli.UI, cfg *config.Config, args []string) error {
+	return runTorrentAction(ui, args, daemon.NewClient(cfg.DaemonAddress).Remove, "Torrent removed")
+}
+
+// runTorrentAction applies a daemon action to the torrent ID given in args
+func runTorrentAction(ui *cli.UI, args []string, action func(id string) error, success string) error {
+	if len(args) != 1 {
+		return errShowUsage
+	}
+	if err := action(args[0]); err != nil {
+		return err
+	}
+	ui.ShowSuccess(success)
+	return nil
+}
+
+// runFiles prints the files of a torrent in the daemon with their priorities
+func runFiles(ui *cli.UI, cfg *config.Config, args []string) error {
+	if len(args) != 1 {
+		return errShowUsage
+	}
+
+	status, err := daemon.NewClient(cfg.DaemonAddress).Get(args[0])
+	if err != nil {
+		return err
+	}
+	if len(status.Files) == 0 {
+		ui.ShowInfo("Torrent metadata is not available yet")
+		return nil
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "INDEX\tPRIORITY\tPROGRESS\tSIZE\tPATH")
+	for _, f := range status.Files {
+		fmt.Fprintf(w, "%d\t%s\t%.1f%%\t%s\t%s\n",
+			f.Index,
+			f.Priority,
+			f.Progress,
+			utils.BytesToString(f.Size),
+			f.Path)
+	}
+	return w.Flush()
+}
+
+// runPeers prints the peers connected to a torrent in the daemon
+func runPeers(ui *cli.UI, cfg *config.Config, args []string) error {
+	if len(args) != 1 {
+		return errShowUsage
+	}
+
+	client := daemon.NewClient(cfg.DaemonAddress)
+	status, err := client.Get(args[0])
+	if err != nil {
+		return err
+	}
+	peers, err := client.Peers(args[0])
+	if err != nil {
+		return err
+	}
+	if len(peers) == 0 {
+		ui.ShowInfo(fmt.Sprintf("No connected peers (encryption: %s)", status.Encryption))
+		return nil
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ADDRESS\tCLIENT\tTYPE\tFLAGS\tDOWN\tUP (EST.)\tHAS")
+	for _, p := range peers {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s/s\t%s/s\t%.1f%%\n",
+			p.Address,
+			orDash(p.Client),
+			p.Connection,
+			orDash(peerFlags(p)),
+			utils.BytesToString(int64(p.DownloadRate)),
+			utils.BytesToString(int64(p.EstimatedUploadRate)),
+			p.Progress)
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	encrypted, unknown := 0, 0
+	for _, p := range peers {
+		switch {
+		case p.Encrypted == nil:
+			unknown++
+		case *p.Encrypted:
+			encrypted++
+		}
+	}
+	fmt.Printf("\nEncryption: %s, %d of %d peers encrypted", status.Encryption, encrypted, len(peers))
+	if unknown > 0 {
+		fmt.Printf(", %d unknown", unknown)
+	}
+	fmt.Println()
+	fmt.Println("Flags: E encrypted, ? encryption unknown, C choking us, I interested in our data, S seed")
+	fmt.Println("UP is estimated: only the torrent's total upload is known, split among the peers by their requests")
+	return nil
+}
+
+// peerFlags abbreviates the state of a peer connection
+func peerFlags(p downloader.PeerStatus) string {
+	var flags string
+	switch {
+	case p.Encrypted == nil:
+		flags += "?"
+	case *p.Encrypted:
+		flags += "E"
+	}
+	if p.Choked {
+		flags += "C"
+	}
+	if p.Interested {
+		flags += "I"
+	}
+	if p.Seed {
+		flags += "S"
+	}
+	return flags
+}
+
+// runTrackers prints the trackers of a torrent in the daemon, adding any given with --add
+func runTrackers(ui *cli.UI, cfg *config.Config, args []string) error {
+	var add listFlag
+	flags := newFlagSet("trackers")
+	flags.Var(&add, "add", "tracker URL to add")
+	args, err := parseFlags(flags, args)
+	if err != nil || len(args) != 1 {
+		return errShowUsage
+	}
+
+	client := daemon.NewClient(cfg.DaemonAddress)
+	if len(add) > 0 {
+		if err := client.AddTrackers(args[0], add); err != nil {
+			return err
+		}
+		ui.ShowSuccess("Trackers added")
+	}
+
+	trackers, err := client.Trackers(args[0])
+	if err != nil {
+		return err
+	}
+	if len(trackers) == 0 {
+		ui.ShowInfo("The torrent has no trackers")
+		return nil
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "TIER\tURL\tSTATUS\tSEEDERS\tLEECHERS\tPEERS\tNEXT\tERROR")
+	for _, tr := range trackers {
+		next := "-"
+		if wait := time.Until(tr.NextAnnounce); tr.Status == downloader.TrackerAnnouncing {
+			next = "now"
+		} else if !tr.NextAnnounce.IsZero() && wait > 0 {
+			next = utils.FormatDuration(wait)
+		}
+		fmt.Fprintf(w, "%d\t%s\t%s\t%d\t%d\t%d\t%s\t%s\n",
+			tr.Tier,
+			tr.URL,
+			tr.Status,
+			tr.Seeders,
+			tr.Leechers,
+			tr.Peers,
+			next,
+			orDash(tr.LastError))
+	}
+	return w.Flush()
+}
+
+// listFlag collects the values of a repeated flag
+type listFlag []string
+
+func (l *listFlag) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *listFlag) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
+
+// runPriority changes file priorities of a torrent in the daemon
+func runPriority(ui *cli.UI, cfg *config.Config, args []string) error {
+	if len(args) < 2 {
+		return errShowUsage
+	}
+
+	priorities := make(priorityFlag)
+	for _, spec := range args[1:] {
+		if err := priorities.Set(spec); err != nil {
+			return err
+		}
+	}
+
+	client := daemon.NewClient(cfg.DaemonAddress)
+	for index, priority := range priorities {
+		if err := client.SetFilePriority(args[0], index, priority); err != nil {
+			return err
+		}
+	}
+	ui.ShowSuccess("File priorities updated")
+	return nil
+}
+
+// priorityFlag collects repeated --priority specs such as "0,2-4=skip"
+type priorityFlag map[int]string
+
+func (p priorityFlag) String() string {
+	return fmt.Sprint(map[int]string(p))
+}
+
+func (p priorityFlag) Set(spec string) error {
+	return downloader.ParseFilePriorities(spec, p)
+}
+
+// sendToDaemon hands the link to a running daemon, reporting whether one was
+// found. The daemon's client is shared by all its torrents, so networkFlags,
+// the network flags given on this command line, cannot apply to the link and
+// are reported as ignored.
+func sendToDaemon(ui *cli.UI, cfg *config.Config, link string, opts downloader.Options, networkFlags []string) (bool, error) {
+	client := daemon.NewClient(cfg.DaemonAddress)
+	if err := client.Ping(); err != nil {
+		return false, nil
+	}
+	if len(networkFlags) > 0 {
+		ui.ShowWarning(fmt.Sprintf("The daemon uses its own network settings, ignoring %s; "+
+			"stop it or restart it with these flags to use them", strings.Join(networkFlags, ", ")))
+	}
+
+	// The daemon may run from another directory, so local files need an absolute path
+	if utils.FileExists(link) {
+		abs, err := utils.GetAbsolutePath(link)
+		if err != nil {
+			return true, err
+		}
+		link = abs
+	}
+
+	status, err := client.Add(link, opts)
+	if err != nil {
+		return true, err
+	}
+
+	ui.ShowSuccess(fmt.Sprintf("Torrent sent to the daemon (%s)", status.ID[:8]))
+	return true, nil
+}
+
+// newFlagSet creates a flag set that reports errors through the usage text
+func newFlagSet(name string) *flag.FlagSet {
+	flags := flag.NewFlagSet(name, flag.ContinueOnError)
+	flags.SetOutput(io.Discard)
+	return flags
+}
+
+// setNetworkFlags returns the network flags, as added by addNetworkFlags, that
+// were given on the command line
+func setNetworkFlags(flags *flag.FlagSet) []string {
+	network := newFlagSet("network")
+	addNetworkFlags(network, &config.Config{})
+
+	var set []string
+	flags.Visit(func(f *flag.Flag) {
+		if network.Lookup(f.Name) != nil {
+			set = append(set, "--"+f.Name)
+		}
+	})
+	return set
+}
+
+// addNetworkFlags registers the flags that override the network and peer discovery settings
+func addNetworkFlags(flags *flag.FlagSet, cfg *config.Config) {
+	flags.Var(&cfg.ListenPort, "port", "port or range of ports to listen on, e.g. 6881-6889")
+	flags.StringVar(&cfg.BindAddress, "bind", cfg.BindAddress, "IP address or network interface to listen on")
+	flags.BoolVar(&cfg.DisableIPv4, "no-ipv4", cfg.DisableIPv4, "do not use IPv4")
+	flags.BoolVar(&cfg.DisableIPv6, "no-ipv6", cfg.DisableIPv6, "do not use IPv6")
+	flags.BoolVar(&cfg.DisableTCP, "no-tcp", cfg.DisableTCP, "do not use TCP for peers")
+	flags.BoolVar(&cfg.DisableUTP, "no-utp", cfg.DisableUTP, "do not use uTP for peers")
+	flags.IntVar(&cfg.MaxConnections, "max-conns", cfg.MaxConnections, "maximum peer connections overall")
+	flags.BoolVar(&cfg.PortForwarding, "port-forward", cfg.PortForwarding, "map the listen port on the router with UPnP and NAT-PMP")
+	flags.StringVar(&cfg.Encryption, "encryption", cfg.Encryption, "peer encryption: prefer, require or disable")
+	flags.IntVar(&cfg.MaxConnectionsPerTorrent, "max-conns-per-torrent", cfg.MaxConnectionsPerTorrent, "maximum peer connections per torrent")
+	flags.StringVar(&cfg.ProxyURL, "proxy", cfg.ProxyURL, "socks5:// or http:// proxy for trackers")
+	flags.BoolVar(&cfg.ProxyPeers, "proxy-peers", cfg.ProxyPeers, "also connect to peers through the proxy")
+	flags.BoolVar(&cfg.DisableDHT, "no-dht", cfg.DisableDHT, "do not use the DHT")
+	flags.BoolVar(&cfg.DisablePEX, "no-pex", cfg.DisablePEX, "do not exchange peers with other peers")
+	flags.BoolVar(&cfg.DisableLSD, "no-lsd", cfg.DisableLSD, "do not look for peers on the local network")
+	flags.Var((*listFlag)(&cfg.Blocklists), "blocklist", "file or URL of IP ranges to block (repeatable)")
+}
+
+// parseFlags parses args allowing flags before and after positional arguments,
+// and returns the positional ones
+func parseFlags(flags *flag.FlagSet, args []string) ([]string, error) {
+	var positional []string
+	for {
+		if err := flags.Parse(args); err != nil {
+			return nil, err
+		}
+		if flags.NArg() == 0 {
+			return positional, nil
+		}
+		positional = append(positional, flags.Arg(0))
+		args = flags.Args()[1:]
+	}
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	"github.com/alucod3/gorrent/internal/cli"
+	"github.com/alucod3/gorrent/internal/config"
+	"github.com/alucod3/gorrent/internal/downloader"
+	"github.com/alucod3/gorrent/internal/history"
+	"github.com/alucod3/gorrent/internal/hooks"
+	"github.com/alucod3/gorrent/pkg/utils"
+)
+
+// runHistory lists past downloads, optionally only those matching a search
+// term, or downloads one of them again
+func runHistory(ui *cli.UI, cfg *config.Config, args []string) error {
+	var redownload int
+	flags := newFlagSet("history")
+	flags.IntVar(&redownload, "redownload", 0, "download the entry with this ID again")
+	addNetworkFlags(flags, cfg)
+	args, err := parseFlags(flags, args)
+	if err != nil || len(args) > 1 || (redownload != 0 && len(args) != 0) {
+		return errShowUsage
+	}
+
+	store := history.New(cfg.HistoryPath())
+	if redownload != 0 {
+		entry, err := store.Get(redownload)
+		if err != nil {
+			return err
+		}
+		return downloadAgain(ui, cfg, entry, setNetworkFlags(flags))
+	}
+
+	entries, err := store.List()
+	if err != nil {
+		return err
+	}
+	if len(args) == 1 {
+		var matches []history.Entry
+		for _, e := range entries {
+			if e.Matches(args[0]) {
+				matches = append(matches, e)
+			}
+		}
+		if len(matches) == 0 {
+			ui.ShowInfo(fmt.Sprintf("No downloads in the history match %q", args[0]))
+			return nil
+		}
+		entries = matches
+	}
+	if len(entries) == 0 {
+		ui.ShowInfo("No downloads in the history")
+		return nil
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tFINISHED\tRESULT\tSIZE\tAVG SPEED\tNAME")
+	for _, e := range entries {
+		name := e.Name
+		if e.Error != "" {
+			name += " (" + e.Error + ")"
+		}
+		fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%s/s\t%s\n",
+			e.ID,
+			e.Finished.Local().Format(time.DateTime),
+			e.Result,
+			utils.BytesToString(e.Size),
+			utils.BytesToString(int64(e.AverageSpeed)),
+			name)
+	}
+	return w.Flush()
+}
+
+// downloadAgain downloads a history entry in the same category, through the
+// daemon when one is running; networkFlags are the network flags given, which
+// the daemon ignores
+func downloadAgain(ui *cli.UI, cfg *config.Config, entry history.Entry, networkFlags []string) error {
+	link := entry.Source()
+	if link == "" {
+		return fmt.Errorf("entry %d has no link to download from", entry.ID)
+	}
+	var opts downloader.Options
+	if cfg.Category(entry.Category) != nil {
+		opts.Category = entry.Category
+	}
+
+	if sent, err := sendToDaemon(ui, cfg, link, opts, networkFlags); sent {
+		return err
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	logger, closeLog, err := openLog(cfg)
+	if err != nil {
+		return err
+	}
+	defer closeLog()
+
+	ui.ShowInfo(fmt.Sprintf("Downloading %s again", entry.Name))
+	dl := downloader.New(cfg, ui.ProgressTracker(), hooks.NewRunner(cfg, logger))
+	err = dl.Download(ctx, link, opts)
+	if errors.Is(err, downloader.ErrDuplicate) {
+		ui.ShowInfo("Skipped, the torrent was already downloaded")
+		return nil
+	}
+	if err != nil && !errors.Is(err, context.Canceled) {
+		return err
+	}
+	return nil
+}
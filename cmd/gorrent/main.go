@@ -4,27 +4,62 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"log"
 	"os"
 	"os/signal"
+	"strconv"
 	"syscall"
 
 	"github.com/alucod3/gorrent/internal/cli"
 	"github.com/alucod3/gorrent/internal/config"
 	"github.com/alucod3/gorrent/internal/downloader"
+	"github.com/alucod3/gorrent/internal/hooks"
 	"github.com/alucod3/gorrent/internal/validator"
 )
 
 const usage = `Usage:
   gorrent                                   # Start interactive mode
   gorrent <magnet-link>                     # Start download with magnet link
-  gorrent <path/to/file.torrent>           # Start download with torrent file`
+  gorrent <path/to/file.torrent>           # Start download with torrent file
+  gorrent <link> --category <name>          # Download into a configured category
+  gorrent <link> --priority <files=prio>    # Set file priorities (skip, normal, high, now)
+  gorrent <link> --sequential [--file N]    # Download a file in order (default: the largest)
+  gorrent <link> --tracker <url>            # Add a tracker (repeatable)
+  gorrent <link> --webseed <url>            # Add a web seed, an HTTP mirror of the files (repeatable)
+  gorrent <link> --port 6881-6889           # Listen on the first free port of a range
+  gorrent <link> --duplicate <action>       # If already downloaded: ask, seed, skip or download
+  gorrent stream <link> [--file N]          # Serve a file over HTTP while it downloads
+  gorrent stream <link> --storage memory    # Stream without writing to disk
+  gorrent daemon [--web]                    # Run the background daemon
+  gorrent watch [--web] [dir...]            # Run the daemon watching folders for .torrent files
+  gorrent list                              # List torrents in the daemon
+  gorrent stats                             # Show daemon totals, refused peers and port mappings
+  gorrent pause <id>                        # Pause a torrent in the daemon
+  gorrent resume <id>                       # Resume a torrent in the daemon
+  gorrent remove <id>                       # Remove a torrent from the daemon
+  gorrent peers <id>                        # List the peers of a torrent in the daemon
+  gorrent trackers <id> [--add <url>]       # Show tracker status, optionally adding trackers
+  gorrent info <file|magnet|id>             # Show torrent details, such as whether it is private
+  gorrent files <id>                        # List the files of a torrent in the daemon
+  gorrent priority <id> <files=prio>...     # Change file priorities in the daemon
+  gorrent history [search]                  # List past downloads, optionally only matching ones
+  gorrent history --redownload <id>         # Download a past entry again
+
+Network flags, accepted when downloading and by stream, daemon and watch:
+  --port <port|first-last>    --bind <ip|interface>    --no-ipv4    --no-ipv6
+  --no-tcp    --no-utp    --max-conns <n>    --max-conns-per-torrent <n>
+  --encryption <prefer|require|disable>    --port-forward
+  --no-dht    --no-pex    --no-lsd    --proxy <url>    --proxy-peers
+  --blocklist <file|url>
+
+When a daemon is running, links are sent to it instead of being downloaded
+by this process.`
 
 func main() {
 	// Initialize the UI
 	ui := cli.NewUI()
-	ui.ClearScreen()
-	ui.ShowLogo()
 
 	// Load settings
 	cfg, err := config.LoadDefaultConfig()
@@ -33,6 +68,24 @@ func main() {
 		os.Exit(1)
 	}
 
+	// Run a subcommand if one was requested
+	if len(os.Args) > 1 {
+		if cmd, ok := commands[os.Args[1]]; ok {
+			if err := cmd(ui, cfg, os.Args[2:]); err != nil {
+				if err == errShowUsage {
+					fmt.Println(usage)
+					os.Exit(2)
+				}
+				ui.ShowError("Error running "+os.Args[1], err)
+				os.Exit(1)
+			}
+			return
+		}
+	}
+
+	ui.ClearScreen()
+	ui.ShowLogo()
+
 	// Create cancelable context to manage lifecycle
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
@@ -48,7 +101,7 @@ func main() {
 	}
 
 	// Get torrent link from args or prompt
-	link, err := getTorrentLink(ui)
+	link, opts, networkFlags, err := getTorrentLink(ui, cfg)
 	if err != nil {
 		if err == errShowUsage {
 			fmt.Println(usage)
@@ -64,14 +117,35 @@ func main() {
 		os.Exit(1)
 	}
 
+	// Hand the link to a running daemon, if there is one
+	if sent, err := sendToDaemon(ui, cfg, link, opts, networkFlags); sent {
+		if err != nil {
+			ui.ShowError("Error sending link to the daemon", err)
+			os.Exit(1)
+		}
+		return
+	}
+
 	ui.ShowSuccess("Valid link! Preparing download...")
 
+	// Hook output goes to the log file so it does not disturb the progress bar
+	logger, closeLog, err := openLog(cfg)
+	if err != nil {
+		ui.ShowError("Error opening log file", err)
+		os.Exit(1)
+	}
+	defer closeLog()
+
 	// Start download
-	dl := downloader.New(cfg, ui.ProgressTracker())
-	if err := dl.Download(ctx, link); err != nil {
+	dl := downloader.New(cfg, ui.ProgressTracker(), hooks.NewRunner(cfg, logger))
+	if err := dl.Download(ctx, link, opts); err != nil {
 		if err == context.Canceled {
 			os.Exit(0)
 		}
+		if errors.Is(err, downloader.ErrDuplicate) {
+			ui.ShowInfo("Skipped, the torrent was already downloaded")
+			return
+		}
 		ui.ShowError("Error during download", err)
 		os.Exit(1)
 	}
@@ -82,27 +156,61 @@ func main() {
 
 var errShowUsage = fmt.Errorf("show usage")
 
-// getTorrentLink returns a torrent link from command line args or prompts the user
-func getTorrentLink(ui *cli.UI) (string, error) {
-	args := os.Args[1:]
+// openLog opens the log file in the state directory for appending
+func openLog(cfg *config.Config) (*log.Logger, func(), error) {
+	if err := cfg.EnsureStateDir(); err != nil {
+		return nil, nil, err
+	}
 
-	// If no args provided, prompt for link
-	if len(args) == 0 {
-		return ui.ReadTorrentLink()
+	file, err := os.OpenFile(cfg.LogPath(), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, nil, err
 	}
+	return log.New(file, "", log.LstdFlags), func() { file.Close() }, nil
+}
+
+// getTorrentLink returns a torrent link, download options and the network
+// flags that were given from command line args, prompting the user for the
+// link if none was given
+func getTorrentLink(ui *cli.UI, cfg *config.Config) (string, downloader.Options, []string, error) {
+	var opts downloader.Options
+	priorities := make(priorityFlag)
+
+	flags := newFlagSet("gorrent")
+	flags.StringVar(&opts.Category, "category", "", "download into this category")
+	flags.Var(priorities, "priority", "set file priorities, e.g. 0,2-4=skip")
+	flags.Var((*listFlag)(&opts.Trackers), "tracker", "add a tracker URL")
+	flags.Var((*listFlag)(&opts.WebSeeds), "webseed", "add a web seed URL")
+	flags.BoolVar(&opts.Sequential, "sequential", false, "download a file in order")
+	flags.StringVar(&opts.Duplicate, "duplicate", "", "what to do if the torrent was already downloaded: ask, seed, skip or download")
+	flags.Func("file", "file index to download in order", func(s string) error {
+		index, err := strconv.Atoi(s)
+		opts.SequentialFile = &index
+		return err
+	})
+	addNetworkFlags(flags, cfg)
+	args, err := parseFlags(flags, os.Args[1:])
+	if err != nil {
+		return "", opts, nil, errShowUsage
+	}
+	if len(priorities) > 0 {
+		opts.FilePriorities = priorities
+	}
+	networkFlags := setNetworkFlags(flags)
 
-	// If help flag provided, show usage
-	if args[0] == "-h" || args[0] == "--help" {
-		return "", errShowUsage
+	// If no link provided, prompt for it
+	if len(args) == 0 {
+		link, err := ui.ReadTorrentLink()
+		return link, opts, networkFlags, err
 	}
 
-	// If more than one arg provided, show usage
+	// If more than one link provided, show usage
 	if len(args) > 1 {
-		return "", errShowUsage
+		return "", opts, nil, errShowUsage
 	}
 
 	// Return the provided link/path
-	return args[0], nil
+	return args[0], opts, networkFlags, nil
 }
 
 // setupSignalHandler configures signal handling for interrupt
@@ -4,8 +4,11 @@ import (
 	"bufio"
 	"fmt"
 	"os"
+	"strconv"
 	"strings"
 	"time"
+
+	"golang.org/x/term"
 )
 
 // UI encapsula toda a lógica da interface com o usuário
@@ -49,6 +52,37 @@ func (ui *UI) ReadTorrentLink() (string, error) {
 	return input, nil
 }
 
+// Interactive indica se a entrada padrão é um terminal, onde dá para perguntar ao usuário
+func (ui *UI) Interactive() bool {
+	return term.IsTerminal(int(os.Stdin.Fd()))
+}
+
+// ReadChoice pergunta ao usuário qual das opções seguir e retorna o índice da
+// escolhida, pelo número ou pelo início do nome; Enter escolhe a primeira
+func (ui *UI) ReadChoice(question string, choices ...string) (int, error) {
+	for i, choice := range choices {
+		ui.colors.Highlight.Printf("   [%d] ", i+1)
+		fmt.Println(choice)
+	}
+	for {
+		ui.colors.Prompt.Printf("❓ %s [1]: ", question)
+		input, err := ui.reader.ReadString('\n')
+		if err != nil {
+			return 0, fmt.Errorf("erro ao ler entrada: %w", err)
+		}
+
+		input = strings.ToLower(strings.TrimSpace(input))
+		if input == "" {
+			return 0, nil
+		}
+		for i, choice := range choices {
+			if input == strconv.Itoa(i+1) || strings.HasPrefix(strings.ToLower(choice), input) {
+				return i, nil
+			}
+		}
+	}
+}
+
 // ShowError exibe uma mensagem de erro
 func (ui *UI) ShowError(message string, err error) {
 	ui.colors.Error.Printf("❌ %s: %v\n", message, err)
@@ -80,7 +114,7 @@ func (ui *UI) PauseForUserFeedback() {
 }
 
 // DisplayTorrentInfo exibe informações detalhadas sobre um torrent
-func (ui *UI) DisplayTorrentInfo(name, size, files, path string) {
+func (ui *UI) DisplayTorrentInfo(name, size, files, path, category, sequential, encryption, portForwarding string) {
 	fmt.Println()
 	ui.colors.Info.Println("📝 Informações do Torrent:")
 	ui.colors.Highlight.Printf("   Nome: ")
@@ -89,6 +123,20 @@ func (ui *UI) DisplayTorrentInfo(name, size, files, path string) {
 	fmt.Println(size)
 	ui.colors.Highlight.Printf("   Arquivos: ")
 	fmt.Println(files)
+	if category != "" {
+		ui.colors.Highlight.Printf("   Categoria: ")
+		fmt.Println(category)
+	}
+	if sequential != "" {
+		ui.colors.Highlight.Printf("   Em ordem: ")
+		fmt.Println(sequential)
+	}
+	ui.colors.Highlight.Printf("   Criptografia: ")
+	fmt.Println(encryption)
+	if portForwarding != "" {
+		ui.colors.Highlight.Printf("   Porta no roteador: ")
+		fmt.Println(portForwarding)
+	}
 	ui.colors.Highlight.Printf("   Salvando em: ")
 	fmt.Println(path)
 	fmt.Println()
@@ -8,6 +8,17 @@ import (
 	"github.com/schollz/progressbar/v3"
 )
 
+// speedSmoothing is the weight given to the newest sample in the moving average
+const speedSmoothing = 0.3
+
+// FileProgress describes the progress of one file of a multi-file torrent
+type FileProgress struct {
+	Path           string
+	Size           int64
+	BytesCompleted int64
+	Skipped        bool
+}
+
 // ProgressUI manages the display of progress bars
 type ProgressUI struct {
 	metadataBar   *progressbar.ProgressBar
@@ -19,6 +30,21 @@ type ProgressUI struct {
 	description   string
 	totalSize     int64
 	bytesComplete int64
+
+	// Session statistics used for the ETA and the final summary
+	sampled    bool
+	startTime  time.Time
+	startBytes int64
+	peakSpeed  float64
+
+	// Per-file progress of multi-file torrents
+	filesDone   map[string]bool
+	filesWanted int
+
+	// Data received from web seeds, shown apart from the peers' share
+	webseedBytes     int64
+	lastWebseedBytes int64
+	webseedSpeed     float64
 }
 
 // NewProgressUI creates a new progress interface
@@ -67,6 +93,15 @@ func (p *ProgressUI) CreateDownloadBar(total int64, description string) {
 	p.description = description
 	p.totalSize = total
 	p.bytesComplete = 0
+	p.sampled = false
+	p.startTime = p.lastTime
+	p.startBytes = 0
+	p.peakSpeed = 0
+	p.filesDone = make(map[string]bool)
+	p.filesWanted = 0
+	p.webseedBytes = 0
+	p.lastWebseedBytes = 0
+	p.webseedSpeed = 0
 
 	// Initial description
 	initialDesc := fmt.Sprintf("%s | Iniciando...", description)
@@ -108,10 +143,25 @@ func (p *ProgressUI) DisplayDownloadStats(bytesCompleted int64, peers int, total
 	currentTime := time.Now()
 	elapsedTime := currentTime.Sub(p.lastTime).Seconds()
 
-	// Avoid division by zero or very small intervals
-	if elapsedTime > 0.1 {
-		p.currentSpeed = float64(bytesCompleted-p.lastBytes) / elapsedTime
+	if !p.sampled {
+		// The first sample only sets the baseline, so data that was already
+		// on disk when the download resumed does not count as speed
+		p.sampled = true
+		p.startBytes = bytesCompleted
+		p.lastBytes = bytesCompleted
+		p.lastWebseedBytes = p.webseedBytes
+		p.lastTime = currentTime
+	} else if elapsedTime > 0.1 {
+		// Avoid division by zero or very small intervals
+		instantSpeed := float64(bytesCompleted-p.lastBytes) / elapsedTime
+		p.currentSpeed = speedSmoothing*instantSpeed + (1-speedSmoothing)*p.currentSpeed
+		if instantSpeed > p.peakSpeed {
+			p.peakSpeed = instantSpeed
+		}
+		webseedSpeed := float64(p.webseedBytes-p.lastWebseedBytes) / elapsedTime
+		p.webseedSpeed = speedSmoothing*webseedSpeed + (1-speedSmoothing)*p.webseedSpeed
 		p.lastBytes = bytesCompleted
+		p.lastWebseedBytes = p.webseedBytes
 		p.lastTime = currentTime
 	}
 
@@ -122,13 +172,17 @@ func (p *ProgressUI) DisplayDownloadStats(bytesCompleted int64, peers int, total
 	// Updates the bar description with the new information
 	var description string
 
-	if p.currentPeers == 0 && p.bytesComplete < p.totalSize {
+	if p.currentPeers == 0 && p.webseedBytes == 0 && p.bytesComplete < p.totalSize {
 		description = fmt.Sprintf("%s | ⚠️  Waiting for peers...", p.description)
 	} else if p.bytesComplete < p.totalSize {
-		description = fmt.Sprintf("%s | 📶 Peers: %d | 🚀 %s/s",
+		description = fmt.Sprintf("%s%s | 📶 Peers: %d | 🚀 %s/s%s | ⏳ ETA %s | ⏱️  %s",
 			p.description,
+			p.filesSummary(),
 			p.currentPeers,
-			utils.BytesToString(int64(p.currentSpeed)))
+			utils.BytesToString(int64(p.peerSpeed())),
+			p.webseedSummary(),
+			p.eta(),
+			utils.FormatDuration(time.Since(p.startTime)))
 	} else {
 		description = p.description + " | Completed!"
 	}
@@ -146,3 +200,83 @@ func (p *ProgressUI) CompleteDownloadBar() {
 		fmt.Println() // Add a line after finish
 	}
 }
+
+// UpdateFileProgress prints files as they finish and keeps the count shown in the bar
+func (p *ProgressUI) UpdateFileProgress(files []FileProgress) {
+	p.filesWanted = 0
+	for _, f := range files {
+		if f.Skipped {
+			continue
+		}
+		p.filesWanted++
+
+		if f.BytesCompleted < f.Size || p.filesDone[f.Path] {
+			continue
+		}
+		p.filesDone[f.Path] = true
+
+		// Print above the bar, which is drawn again on the next update
+		if p.downloadBar != nil {
+			p.downloadBar.Clear()
+		}
+		fmt.Printf("   ✅ %s (%s)\n", f.Path, utils.BytesToString(f.Size))
+	}
+}
+
+// UpdateWebSeedProgress records the bytes received from web seeds so far, so
+// their speed is shown apart from the peers' one
+func (p *ProgressUI) UpdateWebSeedProgress(bytesRead int64) {
+	p.webseedBytes = bytesRead
+}
+
+// peerSpeed returns the part of the download speed that comes from peers
+func (p *ProgressUI) peerSpeed() float64 {
+	return max(0, p.currentSpeed-p.webseedSpeed)
+}
+
+// webseedSummary returns the web seed speed once a web seed has sent data
+func (p *ProgressUI) webseedSummary() string {
+	if p.webseedBytes == 0 {
+		return ""
+	}
+	return fmt.Sprintf(" | 🌐 Web seeds: %s/s", utils.BytesToString(int64(p.webseedSpeed)))
+}
+
+// filesSummary returns the finished file count for multi-file torrents
+func (p *ProgressUI) filesSummary() string {
+	if p.filesWanted == 0 {
+		return ""
+	}
+	return fmt.Sprintf(" | 📁 %d/%d", len(p.filesDone), p.filesWanted)
+}
+
+// eta estimates the remaining time from the smoothed speed
+func (p *ProgressUI) eta() string {
+	if p.currentSpeed < 1 {
+		return "--"
+	}
+	remaining := float64(p.totalSize-p.bytesComplete) / p.currentSpeed
+	return utils.FormatDuration(time.Duration(remaining * float64(time.Second)))
+}
+
+// DisplayDownloadSummary prints the statistics of the finished download session
+func (p *ProgressUI) DisplayDownloadSummary(bytesUploaded int64) {
+	totalTime := time.Since(p.startTime)
+	downloaded := p.bytesComplete - p.startBytes
+
+	var averageSpeed float64
+	if totalTime.Seconds() > 0 {
+		averageSpeed = float64(downloaded) / totalTime.Seconds()
+	}
+
+	fmt.Println("📊 Download summary:")
+	fmt.Printf("   Downloaded: %s\n", utils.BytesToString(downloaded))
+	fmt.Printf("   Total time: %s\n", utils.FormatDuration(totalTime))
+	fmt.Printf("   Average speed: %s/s\n", utils.BytesToString(int64(averageSpeed)))
+	fmt.Printf("   Peak speed: %s/s\n", utils.BytesToString(int64(p.peakSpeed)))
+	if p.webseedBytes > 0 {
+		fmt.Printf("   From web seeds: %s\n", utils.BytesToString(p.webseedBytes))
+	}
+	fmt.Printf("   Uploaded: %s\n", utils.BytesToString(bytesUploaded))
+	fmt.Println()
+}
@@ -1,8 +1,14 @@
 package config
 
 import (
+	"encoding/json"
+	"fmt"
+	"net/url"
 	"os"
 	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
 	"time"
 )
 
@@ -11,27 +17,252 @@ type Config struct {
 	// General Settings
 	AppName    string
 	AppVersion string
+	StateDir   string
 
 	// Download Settings
 	DownloadPath          string
+	IncompletePath        string
+	CompletedPath         string
 	Seed                  bool
 	ProgressCheckInterval time.Duration
+	// Storage selects where piece data is kept: file, mmap, sqlite or memory
+	Storage string
+	// SequentialReadahead is how many bytes ahead of the first missing piece are
+	// prioritized in sequential mode
+	SequentialReadahead int64
+	// DuplicateAction is what to do with a torrent that was already downloaded:
+	// ask, seed (verify and seed the existing copy), skip or download it again.
+	// The daemon cannot ask and seeds instead.
+	DuplicateAction string
+
+	// Daemon Settings
+	DaemonAddress string
+	WebUI         bool
+
+	// Network Settings
+	// ListenPort is the port peers connect to; with a range, the first free port is used
+	ListenPort PortRange
+	// BindAddress is an IP address or network interface name to listen on; empty means all
+	BindAddress string
+	DisableIPv4 bool
+	DisableIPv6 bool
+	DisableTCP  bool
+	DisableUTP  bool
+	// MaxConnectionsPerTorrent limits the peers connected to each torrent
+	MaxConnectionsPerTorrent int
+	// MaxConnections limits the peers connected across all torrents; zero means no limit
+	MaxConnections int
+	// Encryption is the peer connection encryption policy: prefer, require or disable
+	Encryption string
+	// PortForwarding maps ListenPort on the router with UPnP and NAT-PMP while
+	// gorrent runs, so peers outside the local network can connect
+	PortForwarding bool
+
+	// Proxy Settings
+	// ProxyURL is a socks5:// or http:// proxy, with an optional user:password@,
+	// used for tracker requests; empty falls back to HTTPS_PROXY or ALL_PROXY
+	ProxyURL string
+	// ProxyPeers also connects to peers through the proxy. Incoming connections,
+	// uTP, the DHT and local discovery cannot use it and are turned off.
+	ProxyPeers bool
+
+	// Peer Discovery Settings
+	// DHT, peer exchange and local service discovery find peers without a
+	// tracker; private torrents never use them
+	DisableDHT bool
+	DisablePEX bool
+	DisableLSD bool
+
+	// Blocklist Settings
+	// Blocklists are files or http(s) URLs listing IP ranges that peers are
+	// never connected to, in P2P plaintext, DAT (eMule ipfilter) or CIDR format
+	Blocklists []string
+	// BlocklistRefresh is how often the daemon reloads the blocklists; zero never does
+	BlocklistRefresh Duration
+
+	// Streaming Settings
+	StreamAddress string
+
+	// Watch Folder Settings
+	WatchDirs     []string
+	WatchInterval Duration
+
+	// Tracker Settings
+	// DefaultTrackers are appended to every magnet link
+	DefaultTrackers []string
+
+	// Hook Settings
+	Hooks Hooks
+
+	// Categories route downloads to their own directory, limits, seeding policy
+	// and hooks; the first category whose rules match is used
+	Categories []Category
 
 	// Validation Standards
+	// MagnetPattern accepts v1 (btih) and v2 (btmh) info hashes in any xt parameter
 	MagnetPattern    string
 	TorrentExtension string
 }
 
-// LoadDefaultConfig loads default settings and ensures the download path exists
+// Hooks lists what to run when a download completes, fails or is canceled
+type Hooks struct {
+	OnComplete []Hook
+	OnFailure  []Hook
+	OnCancel   []Hook
+}
+
+// Hook is a shell command and/or a webhook URL that receives a JSON payload
+type Hook struct {
+	Command    string
+	WebhookURL string
+	Timeout    Duration
+}
+
+// Category groups downloads that share a destination, limits, seeding policy and hooks
+type Category struct {
+	Name string
+	// Path is where finished downloads are moved to; empty keeps CompletedDir
+	Path string
+	// Rate limits in bytes per second; zero means unlimited
+	DownloadRateLimit int64
+	UploadRateLimit   int64
+	// Seed overrides the global Seed setting when set
+	Seed *bool
+	// SeedRatio stops seeding once uploaded bytes reach this multiple of the size; zero means no limit
+	SeedRatio float64
+	// Hooks run in addition to the global ones
+	Hooks Hooks
+	Match CategoryRules
+}
+
+// CategoryRules select a category automatically once the metadata arrives;
+// matching any one of the rules is enough
+type CategoryRules struct {
+	// NamePattern is a regular expression matched against the torrent name
+	NamePattern string
+	// TrackerHosts match announce URLs on these hosts or their subdomains
+	TrackerHosts []string
+	// Extensions match torrents containing a file with one of these extensions, like ".iso"
+	Extensions []string
+}
+
+// Duration is a time.Duration written as a string like "30s" in the config file
+type Duration struct {
+	time.Duration
+}
+
+// MarshalJSON encodes the duration as a string
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(d.String())
+}
+
+// UnmarshalJSON decodes a duration from a string like "30s"
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+	parsed, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	d.Duration = parsed
+	return nil
+}
+
+// PortRange is a port, or an inclusive range of ports, written as 6881 or
+// "6881-6889" in the config file. Port 0 picks a random free port.
+type PortRange struct {
+	First int
+	Last  int
+}
+
+// String formats the range as "6881" or "6881-6889"
+func (p PortRange) String() string {
+	if p.First == p.Last {
+		return strconv.Itoa(p.First)
+	}
+	return fmt.Sprintf("%d-%d", p.First, p.Last)
+}
+
+// Set parses a port or range, so PortRange can be used as a command line flag
+func (p *PortRange) Set(s string) error {
+	first, last, isRange := strings.Cut(s, "-")
+	if !isRange {
+		last = first
+	}
+
+	var r PortRange
+	var err error
+	if r.First, err = strconv.Atoi(strings.TrimSpace(first)); err != nil {
+		return fmt.Errorf("invalid port %q", s)
+	}
+	if r.Last, err = strconv.Atoi(strings.TrimSpace(last)); err != nil {
+		return fmt.Errorf("invalid port %q", s)
+	}
+	if err := r.validate(); err != nil {
+		return err
+	}
+	*p = r
+	return nil
+}
+
+// validate checks that the range is made of valid ports in order
+func (p PortRange) validate() error {
+	if p.First < 0 || p.Last > 65535 || p.First > p.Last || (p.First == 0 && p.Last != 0) {
+		return fmt.Errorf("invalid port range %s", p)
+	}
+	return nil
+}
+
+// MarshalJSON encodes a single port as a number and a range as a string
+func (p PortRange) MarshalJSON() ([]byte, error) {
+	if p.First == p.Last {
+		return json.Marshal(p.First)
+	}
+	return json.Marshal(p.String())
+}
+
+// UnmarshalJSON decodes a port number or a string like "6881-6889"
+func (p *PortRange) UnmarshalJSON(data []byte) error {
+	var port int
+	if err := json.Unmarshal(data, &port); err == nil {
+		return p.Set(strconv.Itoa(port))
+	}
+
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+	return p.Set(s)
+}
+
+// LoadDefaultConfig loads default settings, applies the user's config file
+// if there is one and ensures the download path exists
 func LoadDefaultConfig() (*Config, error) {
 	cfg := &Config{
-		AppName:               "Gorrent",
-		AppVersion:            "0.1",
-		DownloadPath:          getDefaultDownloadPath(),
-		Seed:                  true,
-		ProgressCheckInterval: 1 * time.Second,
-		MagnetPattern:         `(?i)^magnet:\?xt=urn:btih:[a-zA-Z0-9]{32,40}`,
-		TorrentExtension:      ".torrent",
+		AppName:                  "Gorrent",
+		AppVersion:               "0.1",
+		StateDir:                 getDefaultStateDir(),
+		DownloadPath:             getDefaultDownloadPath(),
+		Seed:                     true,
+		ProgressCheckInterval:    1 * time.Second,
+		SequentialReadahead:      16 << 20,
+		Storage:                  "file",
+		DuplicateAction:          "ask",
+		ListenPort:               PortRange{42069, 42069},
+		MaxConnectionsPerTorrent: 50,
+		Encryption:               "prefer",
+		DaemonAddress:            "127.0.0.1:7881",
+		StreamAddress:            "127.0.0.1:7882",
+		WatchInterval:            Duration{5 * time.Second},
+		BlocklistRefresh:         Duration{24 * time.Hour},
+		MagnetPattern:            `(?i)^magnet:\?(.*&)?xt=urn:(btih:[a-z0-9]{32,40}|btmh:1220[0-9a-f]{64})`,
+		TorrentExtension:         ".torrent",
+	}
+
+	if err := cfg.loadFile(cfg.FilePath()); err != nil {
+		return nil, err
 	}
 
 	if err := cfg.EnsureDownloadPath(); err != nil {
@@ -46,10 +277,192 @@ func getDefaultDownloadPath() string {
 	return filepath.Join(os.Getenv("HOME"), "Downloads")
 }
 
-// EnsureDownloadPath ensures that the download directory exists
+// getDefaultStateDir returns the default directory for gorrent's own files
+func getDefaultStateDir() string {
+	return filepath.Join(os.Getenv("HOME"), ".gorrent")
+}
+
+// FilePath returns the path of the user's config file
+func (c *Config) FilePath() string {
+	return filepath.Join(c.StateDir, "config.json")
+}
+
+// loadFile overrides the settings with the ones in the JSON file at path, if it exists
+func (c *Config) loadFile(path string) error {
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	decoder := json.NewDecoder(file)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(c); err != nil {
+		return fmt.Errorf("invalid config file %s: %w", path, err)
+	}
+	if err := c.validateCategories(); err != nil {
+		return fmt.Errorf("invalid config file %s: %w", path, err)
+	}
+	if err := c.ValidateNetwork(); err != nil {
+		return fmt.Errorf("invalid config file %s: %w", path, err)
+	}
+	if c.WatchInterval.Duration <= 0 {
+		return fmt.Errorf("invalid config file %s: WatchInterval must be positive, got %s", path, c.WatchInterval.Duration)
+	}
+	return nil
+}
+
+// validateCategories checks that category names are unique and rules compile
+func (c *Config) validateCategories() error {
+	seen := make(map[string]bool)
+	for _, category := range c.Categories {
+		if category.Name == "" {
+			return fmt.Errorf("category without a name")
+		}
+		if seen[category.Name] {
+			return fmt.Errorf("duplicate category %q", category.Name)
+		}
+		seen[category.Name] = true
+
+		if category.Match.NamePattern != "" {
+			if _, err := regexp.Compile(category.Match.NamePattern); err != nil {
+				return fmt.Errorf("category %q: %w", category.Name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// ValidateNetwork checks that the network settings leave a way to reach peers
+func (c *Config) ValidateNetwork() error {
+	if err := c.ListenPort.validate(); err != nil {
+		return err
+	}
+	if c.DisableIPv4 && c.DisableIPv6 {
+		return fmt.Errorf("IPv4 and IPv6 cannot both be disabled")
+	}
+	if c.DisableTCP && c.DisableUTP {
+		return fmt.Errorf("TCP and uTP cannot both be disabled")
+	}
+	if c.MaxConnectionsPerTorrent <= 0 {
+		return fmt.Errorf("MaxConnectionsPerTorrent must be positive")
+	}
+	if c.MaxConnections < 0 {
+		return fmt.Errorf("MaxConnections cannot be negative")
+	}
+	return nil
+}
+
+// Category returns the category with the given name, or nil if there is none
+func (c *Config) Category(name string) *Category {
+	for i := range c.Categories {
+		if c.Categories[i].Name == name {
+			return &c.Categories[i]
+		}
+	}
+	return nil
+}
+
+// LogPath returns the path of the log file used outside daemon mode
+func (c *Config) LogPath() string {
+	return filepath.Join(c.StateDir, "gorrent.log")
+}
+
+// Proxy returns the proxy to use, from ProxyURL or the environment, or nil if
+// there is none
+func (c *Config) Proxy() (*url.URL, error) {
+	raw, source := c.ProxyURL, "ProxyURL"
+	for _, env := range []string{"HTTPS_PROXY", "https_proxy", "ALL_PROXY", "all_proxy"} {
+		if raw != "" {
+			break
+		}
+		raw, source = os.Getenv(env), env
+	}
+	if raw == "" {
+		return nil, nil
+	}
+
+	u, err := url.Parse(raw)
+	if err != nil || u.Host == "" {
+		return nil, fmt.Errorf("invalid proxy in %s: %q", source, raw)
+	}
+	switch u.Scheme {
+	case "socks5", "socks5h", "http":
+		return u, nil
+	default:
+		return nil, fmt.Errorf("unsupported proxy in %s: %q, use socks5:// or http://", source, raw)
+	}
+}
+
+// BlocklistCacheDir returns where downloaded blocklists are kept, so they can
+// still be loaded when their URL is unreachable
+func (c *Config) BlocklistCacheDir() string {
+	return filepath.Join(c.StateDir, "blocklists")
+}
+
+// HistoryPath returns where finished, failed and canceled downloads are recorded
+func (c *Config) HistoryPath() string {
+	return filepath.Join(c.StateDir, "history.jsonl")
+}
+
+// SessionPath returns where the daemon keeps its torrents to restore them on restart
+func (c *Config) SessionPath() string {
+	return filepath.Join(c.StateDir, "session.jsonl")
+}
+
+// SessionQuarantinePath returns where session entries that could not be
+// restored are set aside
+func (c *Config) SessionQuarantinePath() string {
+	return filepath.Join(c.StateDir, "session-quarantine.jsonl")
+}
+
+// DHTNodesPath returns where the DHT routing table is kept between runs
+func (c *Config) DHTNodesPath() string {
+	return filepath.Join(c.StateDir, "dht.dat")
+}
+
+// IncompleteDir returns where data is written while a download is running
+func (c *Config) IncompleteDir() string {
+	if c.IncompletePath != "" {
+		return c.IncompletePath
+	}
+	return c.DownloadPath
+}
+
+// CompletedDir returns where finished downloads are moved to
+func (c *Config) CompletedDir() string {
+	if c.CompletedPath != "" {
+		return c.CompletedPath
+	}
+	return c.DownloadPath
+}
+
+// EnsureDownloadPath ensures that the download directories exist
 func (c *Config) EnsureDownloadPath() error {
-	if _, err := os.Stat(c.DownloadPath); os.IsNotExist(err) {
-		return os.MkdirAll(c.DownloadPath, 0755)
+	dirs := []string{c.DownloadPath, c.IncompleteDir(), c.CompletedDir()}
+	for _, category := range c.Categories {
+		if category.Path != "" {
+			dirs = append(dirs, category.Path)
+		}
+	}
+
+	for _, dir := range dirs {
+		if _, err := os.Stat(dir); os.IsNotExist(err) {
+			if err := os.MkdirAll(dir, 0755); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// EnsureStateDir ensures that the state directory exists
+func (c *Config) EnsureStateDir() error {
+	if _, err := os.Stat(c.StateDir); os.IsNotExist(err) {
+		return os.MkdirAll(c.StateDir, 0755)
 	}
 	return nil
 }
@@ -0,0 +1,93 @@
+package config
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestPortRangeSet(t *testing.T) {
+	tests := []struct {
+		in   string
+		want PortRange
+		err  bool
+	}{
+		{in: "6881", want: PortRange{6881, 6881}},
+		{in: "6881-6889", want: PortRange{6881, 6889}},
+		{in: " 6881 - 6889 ", want: PortRange{6881, 6889}},
+		{in: "6881-6881", want: PortRange{6881, 6881}},
+		{in: "0", want: PortRange{0, 0}},
+		{in: "1-65535", want: PortRange{1, 65535}},
+		{in: "", err: true},
+		{in: "-", err: true},
+		{in: "port", err: true},
+		{in: "6889-6881", err: true},
+		{in: "-6881", err: true},
+		{in: "6881-", err: true},
+		{in: "6881-6885-6889", err: true},
+		{in: "65536", err: true},
+		{in: "6881-70000", err: true},
+		{in: "0-6881", err: true},
+	}
+
+	for _, tt := range tests {
+		p := PortRange{1, 2}
+		err := p.Set(tt.in)
+		if tt.err {
+			if err == nil {
+				t.Errorf("%q accepted as %v", tt.in, p)
+			}
+			// An invalid value leaves the previous one in place
+			if p != (PortRange{1, 2}) {
+				t.Errorf("%q changed the range to %v", tt.in, p)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: %v", tt.in, err)
+			continue
+		}
+		if p != tt.want {
+			t.Errorf("%q parsed as %v, want %v", tt.in, p, tt.want)
+		}
+	}
+}
+
+func TestPortRangeJSON(t *testing.T) {
+	tests := []struct {
+		json string
+		want PortRange
+		err  bool
+	}{
+		{json: `6881`, want: PortRange{6881, 6881}},
+		{json: `"6881"`, want: PortRange{6881, 6881}},
+		{json: `"6881-6889"`, want: PortRange{6881, 6889}},
+		{json: `"6889-6881"`, err: true},
+		{json: `70000`, err: true},
+		{json: `true`, err: true},
+	}
+
+	for _, tt := range tests {
+		var p PortRange
+		err := json.Unmarshal([]byte(tt.json), &p)
+		if tt.err {
+			if err == nil {
+				t.Errorf("%s accepted as %v", tt.json, p)
+			}
+			continue
+		}
+		if err != nil || p != tt.want {
+			t.Errorf("%s decoded as %v, %v; want %v", tt.json, p, err, tt.want)
+			continue
+		}
+
+		// The range is written back in a form that decodes to the same value
+		data, err := json.Marshal(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var back PortRange
+		if err := json.Unmarshal(data, &back); err != nil || back != p {
+			t.Errorf("%s encoded as %s and decoded as %v, %v", tt.json, data, back, err)
+		}
+	}
+}
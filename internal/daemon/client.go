@@ -0,0 +1,156 @@
+package daemon
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/alucod3/gorrent/internal/downloader"
+)
+
+// Client conversa com um daemon em execução através da API local
+type Client struct {
+	baseURL string
+	http    *http.Client
+}
+
+// NewClient cria um cliente para o daemon no endereço informado
+func NewClient(address string) *Client {
+	return &Client{
+		baseURL: "http://" + address,
+		http:    &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+// Ping verifica rapidamente se há um daemon respondendo
+func (c *Client) Ping() error {
+	req, err := http.NewRequest(http.MethodGet, c.baseURL+"/api/version", nil)
+	if err != nil {
+		return err
+	}
+
+	quick := &http.Client{Timeout: 500 * time.Millisecond}
+	resp, err := quick.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	var version VersionResponse
+	if err := json.NewDecoder(resp.Body).Decode(&version); err != nil {
+		return fmt.Errorf("resposta inesperada do daemon: %w", err)
+	}
+	return nil
+}
+
+// Add envia um link para o daemon baixar
+func (c *Client) Add(link string, opts downloader.Options) (downloader.Status, error) {
+	var status downloader.Status
+	err := c.do(http.MethodPost, "/api/torrents", AddRequest{Link: link, Options: opts}, &status)
+	return status, err
+}
+
+// Stats retorna os totais do daemon, incluindo os bloqueios das listas de IPs
+func (c *Client) Stats() (downloader.Stats, error) {
+	var stats downloader.Stats
+	err := c.do(http.MethodGet, "/api/stats", nil, &stats)
+	return stats, err
+}
+
+// List retorna os torrents gerenciados pelo daemon
+func (c *Client) List() ([]downloader.Status, error) {
+	var list []downloader.Status
+	err := c.do(http.MethodGet, "/api/torrents", nil, &list)
+	return list, err
+}
+
+// Get retorna o estado de um torrent do daemon, incluindo seus arquivos
+func (c *Client) Get(id string) (downloader.Status, error) {
+	var status downloader.Status
+	err := c.do(http.MethodGet, "/api/torrents/"+url.PathEscape(id), nil, &status)
+	return status, err
+}
+
+// Peers retorna os peers conectados a um torrent no daemon
+func (c *Client) Peers(id string) ([]downloader.PeerStatus, error) {
+	var peers []downloader.PeerStatus
+	err := c.do(http.MethodGet, "/api/torrents/"+url.PathEscape(id)+"/peers", nil, &peers)
+	return peers, err
+}
+
+// Trackers retorna o estado dos trackers de um torrent no daemon
+func (c *Client) Trackers(id string) ([]downloader.TrackerStatus, error) {
+	var trackers []downloader.TrackerStatus
+	err := c.do(http.MethodGet, "/api/torrents/"+url.PathEscape(id)+"/trackers", nil, &trackers)
+	return trackers, err
+}
+
+// AddTrackers acrescenta trackers a um torrent no daemon
+func (c *Client) AddTrackers(id string, trackers []string) error {
+	return c.do(http.MethodPost, "/api/torrents/"+url.PathEscape(id)+"/trackers", TrackersRequest{Trackers: trackers}, nil)
+}
+
+// SetFilePriority muda a prioridade de um arquivo de um torrent no daemon
+func (c *Client) SetFilePriority(id string, index int, priority string) error {
+	path := fmt.Sprintf("/api/torrents/%s/files/%d/priority", url.PathEscape(id), index)
+	return c.do(http.MethodPost, path, PriorityRequest{Priority: priority}, nil)
+}
+
+// Pause pausa um torrent no daemon
+func (c *Client) Pause(id string) error {
+	return c.do(http.MethodPost, "/api/torrents/"+url.PathEscape(id)+"/pause", nil, nil)
+}
+
+// Resume retoma um torrent pausado no daemon
+func (c *Client) Resume(id string) error {
+	return c.do(http.MethodPost, "/api/torrents/"+url.PathEscape(id)+"/resume", nil, nil)
+}
+
+// Remove retira um torrent do daemon
+func (c *Client) Remove(id string) error {
+	return c.do(http.MethodDelete, "/api/torrents/"+url.PathEscape(id), nil, nil)
+}
+
+// do executa uma requisição JSON e decodifica a resposta em out, se informado
+func (c *Client) do(method, path string, body, out any) error {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequest(method, c.baseURL+path, reader)
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return fmt.Errorf("erro ao contatar o daemon: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 400 {
+		var apiErr errorResponse
+		if err := json.NewDecoder(resp.Body).Decode(&apiErr); err != nil || apiErr.Error == "" {
+			return fmt.Errorf("daemon respondeu %s", resp.Status)
+		}
+		return errors.New(apiErr.Error)
+	}
+
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
@@ -0,0 +1,65 @@
+package daemon
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// errUnsupportedMediaType indica um corpo que não foi enviado como JSON
+var errUnsupportedMediaType = errors.New("o corpo da requisição deve ser application/json")
+
+// checkRequest recusa requisições que uma página qualquer aberta no navegador
+// conseguiria fazer à API local. Um Host com nome diferente de localhost vem
+// de um domínio que passou a apontar para o daemon (DNS rebinding), e um
+// Origin de outro endereço em uma requisição que altera algo vem de outro site.
+func checkRequest(r *http.Request) error {
+	host := r.Host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	host = strings.Trim(host, "[]")
+	if !strings.EqualFold(host, "localhost") && net.ParseIP(host) == nil {
+		return fmt.Errorf("host não permitido: %s", r.Host)
+	}
+
+	if r.Method == http.MethodGet || r.Method == http.MethodHead {
+		return nil
+	}
+	if r.Header.Get("Sec-Fetch-Site") == "cross-site" {
+		return errors.New("requisição de outro site não permitida")
+	}
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return nil
+	}
+	u, err := url.Parse(origin)
+	if err != nil || u.Host != r.Host {
+		return fmt.Errorf("origem não permitida: %s", origin)
+	}
+	return nil
+}
+
+// decodeJSON lê o corpo JSON da requisição em v. Exigir o Content-Type impede
+// que um formulário de outro site envie o corpo como texto simples.
+func decodeJSON(r *http.Request, v any) error {
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil || mediaType != "application/json" {
+		return errUnsupportedMediaType
+	}
+	return json.NewDecoder(r.Body).Decode(v)
+}
+
+// writeDecodeError responde ao erro de decodeJSON com o código adequado
+func writeDecodeError(w http.ResponseWriter, err error) {
+	if errors.Is(err, errUnsupportedMediaType) {
+		writeError(w, http.StatusUnsupportedMediaType, err)
+		return
+	}
+	writeError(w, http.StatusBadRequest, err)
+}
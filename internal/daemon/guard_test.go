@@ -0,0 +1,97 @@
+package daemon
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestCheckRequest(t *testing.T) {
+	tests := []struct {
+		name    string
+		method  string
+		host    string
+		headers map[string]string
+		ok      bool
+	}{
+		{name: "cliente local", method: http.MethodPost, host: "127.0.0.1:7881", ok: true},
+		{name: "localhost", method: http.MethodGet, host: "localhost:7881", ok: true},
+		{name: "ipv6", method: http.MethodGet, host: "[::1]:7881", ok: true},
+		{name: "ip da rede", method: http.MethodGet, host: "192.168.0.10:7881", ok: true},
+		{name: "dns rebinding", method: http.MethodGet, host: "evil.example:7881"},
+		{name: "dns rebinding sem porta", method: http.MethodPost, host: "evil.example"},
+		{
+			name: "mesma origem", method: http.MethodPost, host: "127.0.0.1:7881",
+			headers: map[string]string{"Origin": "http://127.0.0.1:7881", "Sec-Fetch-Site": "same-origin"},
+			ok:      true,
+		},
+		{
+			name: "outra origem", method: http.MethodPost, host: "127.0.0.1:7881",
+			headers: map[string]string{"Origin": "https://evil.example"},
+		},
+		{
+			name: "outra porta", method: http.MethodDelete, host: "127.0.0.1:7881",
+			headers: map[string]string{"Origin": "http://127.0.0.1:8080"},
+		},
+		{
+			name: "origem nula", method: http.MethodPost, host: "127.0.0.1:7881",
+			headers: map[string]string{"Origin": "null"},
+		},
+		{
+			name: "outro site sem origem", method: http.MethodPost, host: "127.0.0.1:7881",
+			headers: map[string]string{"Sec-Fetch-Site": "cross-site"},
+		},
+		{
+			name: "leitura de outra origem", method: http.MethodGet, host: "127.0.0.1:7881",
+			headers: map[string]string{"Origin": "https://evil.example"},
+			ok:      true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(tt.method, "/api/torrents", nil)
+			r.Host = tt.host
+			for k, v := range tt.headers {
+				r.Header.Set(k, v)
+			}
+			err := checkRequest(r)
+			if tt.ok && err != nil {
+				t.Fatalf("checkRequest recusou: %v", err)
+			}
+			if !tt.ok && err == nil {
+				t.Fatal("checkRequest aceitou")
+			}
+		})
+	}
+}
+
+func TestDecodeJSON(t *testing.T) {
+	tests := []struct {
+		contentType string
+		err         error
+	}{
+		{contentType: "application/json"},
+		{contentType: "application/json; charset=utf-8"},
+		{contentType: "text/plain", err: errUnsupportedMediaType},
+		{contentType: "application/x-www-form-urlencoded", err: errUnsupportedMediaType},
+		{contentType: "", err: errUnsupportedMediaType},
+	}
+
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodPost, "/api/torrents", strings.NewReader(`{"link":"magnet:?xt=urn:btih:x"}`))
+		if tt.contentType != "" {
+			r.Header.Set("Content-Type", tt.contentType)
+		}
+		var req AddRequest
+		err := decodeJSON(r, &req)
+		if !errors.Is(err, tt.err) {
+			t.Errorf("%q: erro %v, esperado %v", tt.contentType, err, tt.err)
+		}
+		if tt.err == nil && req.Link == "" {
+			t.Errorf("%q: corpo não foi lido", tt.contentType)
+		}
+	}
+}
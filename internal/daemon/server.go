@@ -0,0 +1,257 @@
+// Package daemon expõe um gerenciador de downloads de longa duração
+// através de uma API HTTP/JSON local.
+package daemon
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/alucod3/gorrent/internal/config"
+	"github.com/alucod3/gorrent/internal/downloader"
+	"github.com/alucod3/gorrent/internal/validator"
+)
+
+// AddRequest é o corpo da requisição para adicionar um torrent
+type AddRequest struct {
+	Link string `json:"link"`
+	downloader.Options
+}
+
+// PriorityRequest é o corpo da requisição para mudar a prioridade de um arquivo
+type PriorityRequest struct {
+	Priority string `json:"priority"`
+}
+
+// TrackersRequest é o corpo da requisição para acrescentar trackers a um torrent
+type TrackersRequest struct {
+	Trackers []string `json:"trackers"`
+}
+
+// VersionResponse identifica o daemon em execução
+type VersionResponse struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+// errorResponse é o corpo das respostas de erro
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+// Server expõe a API de controle local do daemon
+type Server struct {
+	config    *config.Config
+	manager   *downloader.Manager
+	validator *validator.Validator
+	mux       *http.ServeMux
+}
+
+// NewServer cria o servidor da API para o gerenciador informado
+func NewServer(cfg *config.Config, manager *downloader.Manager) *Server {
+	s := &Server{
+		config:    cfg,
+		manager:   manager,
+		validator: validator.WithConfig(cfg),
+		mux:       http.NewServeMux(),
+	}
+	s.routes()
+	return s
+}
+
+// routes registra os endpoints da API
+func (s *Server) routes() {
+	s.mux.HandleFunc("GET /api/version", s.handleVersion)
+	s.mux.HandleFunc("GET /api/stats", s.handleStats)
+	s.mux.HandleFunc("GET /api/torrents", s.handleList)
+	s.mux.HandleFunc("POST /api/torrents", s.handleAdd)
+	s.mux.HandleFunc("GET /api/torrents/{id}", s.handleGet)
+	s.mux.HandleFunc("GET /api/torrents/{id}/peers", s.handlePeers)
+	s.mux.HandleFunc("GET /api/torrents/{id}/trackers", s.handleTrackers)
+	s.mux.HandleFunc("POST /api/torrents/{id}/trackers", s.handleAddTrackers)
+	s.mux.HandleFunc("POST /api/torrents/{id}/pause", s.handlePause)
+	s.mux.HandleFunc("POST /api/torrents/{id}/resume", s.handleResume)
+	s.mux.HandleFunc("DELETE /api/torrents/{id}", s.handleRemove)
+	s.mux.HandleFunc("POST /api/torrents/{id}/files/{index}/priority", s.handlePriority)
+
+	if s.config.WebUI {
+		s.webRoutes()
+	}
+}
+
+// ServeHTTP permite usar o servidor como http.Handler
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if err := checkRequest(r); err != nil {
+		writeError(w, http.StatusForbidden, err)
+		return
+	}
+	s.mux.ServeHTTP(w, r)
+}
+
+// Run atende a API no endereço configurado até o contexto ser cancelado
+func (s *Server) Run(ctx context.Context) error {
+	listener, err := net.Listen("tcp", s.config.DaemonAddress)
+	if err != nil {
+		return err
+	}
+
+	server := &http.Server{
+		Handler:           s,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		server.Shutdown(shutdownCtx)
+	}()
+
+	if err := server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+func (s *Server) handleVersion(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, VersionResponse{
+		Name:    s.config.AppName,
+		Version: s.config.AppVersion,
+	})
+}
+
+func (s *Server) handleStats(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, s.manager.Stats())
+}
+
+func (s *Server) handleList(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, s.manager.List())
+}
+
+func (s *Server) handleAdd(w http.ResponseWriter, r *http.Request) {
+	var req AddRequest
+	if err := decodeJSON(r, &req); err != nil {
+		writeDecodeError(w, err)
+		return
+	}
+
+	if err := s.validator.IsValidTorrentLink(req.Link); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	status, err := s.manager.Add(req.Link, req.Options)
+	if err != nil {
+		writeError(w, http.StatusUnprocessableEntity, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, status)
+}
+
+func (s *Server) handleGet(w http.ResponseWriter, r *http.Request) {
+	status, err := s.manager.Get(r.PathValue("id"))
+	if err != nil {
+		writeManagerError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, status)
+}
+
+func (s *Server) handlePeers(w http.ResponseWriter, r *http.Request) {
+	peers, err := s.manager.Peers(r.PathValue("id"))
+	if err != nil {
+		writeManagerError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, peers)
+}
+
+func (s *Server) handleTrackers(w http.ResponseWriter, r *http.Request) {
+	trackers, err := s.manager.Trackers(r.PathValue("id"))
+	if err != nil {
+		writeManagerError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, trackers)
+}
+
+func (s *Server) handleAddTrackers(w http.ResponseWriter, r *http.Request) {
+	var req TrackersRequest
+	if err := decodeJSON(r, &req); err != nil {
+		writeDecodeError(w, err)
+		return
+	}
+
+	if err := s.manager.AddTrackers(r.PathValue("id"), req.Trackers); err != nil {
+		writeManagerError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) handlePause(w http.ResponseWriter, r *http.Request) {
+	s.handleAction(w, r, s.manager.Pause)
+}
+
+func (s *Server) handleResume(w http.ResponseWriter, r *http.Request) {
+	s.handleAction(w, r, s.manager.Resume)
+}
+
+func (s *Server) handleRemove(w http.ResponseWriter, r *http.Request) {
+	s.handleAction(w, r, s.manager.Remove)
+}
+
+func (s *Server) handlePriority(w http.ResponseWriter, r *http.Request) {
+	index, err := strconv.Atoi(r.PathValue("index"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("índice de arquivo inválido: %w", err))
+		return
+	}
+
+	var req PriorityRequest
+	if err := decodeJSON(r, &req); err != nil {
+		writeDecodeError(w, err)
+		return
+	}
+
+	if err := s.manager.SetFilePriority(r.PathValue("id"), index, req.Priority); err != nil {
+		writeManagerError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleAction executa uma operação do gerenciador sobre o torrent da URL
+func (s *Server) handleAction(w http.ResponseWriter, r *http.Request, action func(id string) error) {
+	if err := action(r.PathValue("id")); err != nil {
+		writeManagerError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// writeManagerError traduz erros do gerenciador para códigos HTTP
+func writeManagerError(w http.ResponseWriter, err error) {
+	if errors.Is(err, downloader.ErrNotFound) {
+		writeError(w, http.StatusNotFound, err)
+		return
+	}
+	writeError(w, http.StatusBadRequest, err)
+}
+
+// writeJSON escreve uma resposta JSON com o código informado
+func writeJSON(w http.ResponseWriter, code int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(v)
+}
+
+// writeError escreve uma resposta de erro em JSON
+func writeError(w http.ResponseWriter, code int, err error) {
+	writeJSON(w, code, errorResponse{Error: err.Error()})
+}
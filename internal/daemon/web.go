@@ -0,0 +1,83 @@
+package daemon
+
+import (
+	"embed"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"time"
+
+	"github.com/alucod3/gorrent/internal/downloader"
+)
+
+// maxUploadSize limita o tamanho dos arquivos .torrent enviados pela interface web
+const maxUploadSize = 10 << 20
+
+//go:embed web
+var webAssets embed.FS
+
+// webRoutes registra a interface web e os endpoints usados por ela
+func (s *Server) webRoutes() {
+	assets, err := fs.Sub(webAssets, "web")
+	if err != nil {
+		panic(err)
+	}
+
+	s.mux.Handle("GET /", http.FileServerFS(assets))
+	s.mux.HandleFunc("POST /api/torrents/upload", s.handleUpload)
+	s.mux.HandleFunc("GET /api/events", s.handleEvents)
+}
+
+// handleUpload adiciona um torrent a partir de um arquivo .torrent enviado por formulário
+func (s *Server) handleUpload(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxUploadSize)
+
+	file, _, err := r.FormFile("file")
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	defer file.Close()
+
+	opts := downloader.Options{Category: r.FormValue("category")}
+	status, err := s.manager.AddTorrentFile(file, opts)
+	if err != nil {
+		writeError(w, http.StatusUnprocessableEntity, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, status)
+}
+
+// handleEvents envia a lista de torrents periodicamente via Server-Sent Events
+func (s *Server) handleEvents(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, http.StatusInternalServerError, fmt.Errorf("streaming não suportado"))
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+
+	ticker := time.NewTicker(s.config.ProgressCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		data, err := json.Marshal(s.manager.List())
+		if err != nil {
+			return
+		}
+		if _, err := fmt.Fprintf(w, "event: torrents\ndata: %s\n\n", data); err != nil {
+			return
+		}
+		flusher.Flush()
+
+		select {
+		case <-ticker.C:
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,331 @@
+"use strict";
+
+const torrentsBody = document.querySelector("#torrents tbody");
+const emptyMessage = document.getElementById("empty");
+const message = document.getElementById("message");
+const connection = document.getElementById("connection");
+const details = document.getElementById("details");
+const statsLine = document.getElementById("stats");
+
+const priorities = ["skip", "normal", "high", "now"];
+
+let selectedID = null;
+
+function formatBytes(bytes) {
+  const units = ["B", "KB", "MB", "GB", "TB", "PB"];
+  let value = bytes;
+  let unit = 0;
+  while (value >= 1024 && unit < units.length - 1) {
+    value /= 1024;
+    unit++;
+  }
+  return unit === 0 ? `${value} B` : `${value.toFixed(2)} ${units[unit]}`;
+}
+
+function showMessage(text, isError) {
+  message.textContent = text;
+  message.className = isError ? "error" : "";
+}
+
+async function api(method, path, body) {
+  // The daemon only accepts JSON bodies sent as such; forms set their own type
+  const headers = typeof body === "string" ? { "Content-Type": "application/json" } : {};
+  const response = await fetch(path, { method, body, headers });
+  if (!response.ok) {
+    const data = await response.json().catch(() => ({}));
+    throw new Error(data.error || response.statusText);
+  }
+  return response.status === 204 ? null : response.json();
+}
+
+function actionButton(label, handler) {
+  const button = document.createElement("button");
+  button.className = "secondary";
+  button.textContent = label;
+  button.addEventListener("click", (event) => {
+    event.stopPropagation();
+    handler().catch((err) => showMessage(err.message, true));
+  });
+  return button;
+}
+
+function renderTorrents(torrents) {
+  torrentsBody.replaceChildren();
+  emptyMessage.hidden = torrents.length > 0;
+
+  for (const t of torrents) {
+    const row = document.createElement("tr");
+    if (t.id === selectedID) {
+      row.className = "selected";
+    }
+
+    const progress = document.createElement("progress");
+    progress.max = 100;
+    progress.value = t.progress;
+
+    const cells = [
+      t.name || t.id,
+      t.category || "-",
+      t.state,
+      progress,
+      formatBytes(t.size),
+      String(t.peers),
+      `${formatBytes(Math.round(t.download_speed))}/s`,
+      `${formatBytes(Math.round(t.upload_speed))}/s`,
+    ];
+    for (const value of cells) {
+      const cell = document.createElement("td");
+      cell.append(value);
+      row.append(cell);
+    }
+
+    const actions = document.createElement("td");
+    if (t.state === "paused") {
+      actions.append(actionButton("Resume", () => api("POST", `/api/torrents/${t.id}/resume`)));
+    } else {
+      actions.append(actionButton("Pause", () => api("POST", `/api/torrents/${t.id}/pause`)));
+    }
+    actions.append(" ", actionButton("Remove", () => api("DELETE", `/api/torrents/${t.id}`)));
+    row.append(actions);
+
+    row.addEventListener("click", () => {
+      selectedID = t.id;
+      loadDetails();
+    });
+    torrentsBody.append(row);
+  }
+}
+
+async function loadStats() {
+  try {
+    const stats = await api("GET", "/api/stats");
+    let text = `${stats.peers} peers, ${formatBytes(Math.round(stats.download_speed))}/s down, ` +
+      `${formatBytes(Math.round(stats.upload_speed))}/s up`;
+    const mapped = (stats.port_mappings || []).filter((m) => !m.error);
+    if (mapped.length > 0) {
+      text += ` · port ${mapped[0].internal_port} mapped to ${mapped[0].external_port} (${mapped[0].method})`;
+    } else if (stats.port_mappings) {
+      text += " · port mapping failed";
+    }
+    if (stats.blocklist) {
+      text += ` · ${stats.blocklist.refused} blocked peer addresses and connections refused by ${stats.blocklist.ranges} ranges`;
+      if (stats.blocklist.skipped) {
+        text += `, ${stats.blocklist.skipped} malformed lines skipped`;
+      }
+      if (stats.blocklist.error) {
+        text += ` (${stats.blocklist.error})`;
+      }
+    }
+    statsLine.textContent = text;
+  } catch (err) {
+    statsLine.textContent = "";
+  }
+}
+
+function prioritySelect(id, file) {
+  const select = document.createElement("select");
+  for (const priority of priorities) {
+    const option = document.createElement("option");
+    option.value = priority;
+    option.textContent = priority;
+    option.selected = priority === file.priority;
+    select.append(option);
+  }
+  select.addEventListener("change", async () => {
+    try {
+      const body = JSON.stringify({ priority: select.value });
+      await api("POST", `/api/torrents/${id}/files/${file.index}/priority`, body);
+    } catch (err) {
+      showMessage(err.message, true);
+    }
+    select.blur();
+  });
+  return select;
+}
+
+async function loadDetails() {
+  if (!selectedID) {
+    return;
+  }
+
+  let torrent;
+  let peers;
+  let trackers;
+  try {
+    [torrent, peers, trackers] = await Promise.all([
+      api("GET", `/api/torrents/${selectedID}`),
+      api("GET", `/api/torrents/${selectedID}/peers`),
+      api("GET", `/api/torrents/${selectedID}/trackers`),
+    ]);
+  } catch (err) {
+    selectedID = null;
+    details.hidden = true;
+    return;
+  }
+
+  document.getElementById("details-name").textContent = torrent.name || torrent.id;
+  renderPeers(peers, torrent.encryption);
+  renderTrackers(trackers);
+  details.hidden = false;
+
+  const files = document.getElementById("files");
+
+  // Keep the table while a priority is being chosen
+  if (files.contains(document.activeElement)) {
+    return;
+  }
+
+  files.replaceChildren();
+  for (const f of torrent.files || []) {
+    const row = document.createElement("tr");
+    for (const value of [f.path, formatBytes(f.size), `${f.progress.toFixed(1)}%`]) {
+      const cell = document.createElement("td");
+      cell.textContent = value;
+      row.append(cell);
+    }
+
+    const cell = document.createElement("td");
+    cell.append(prioritySelect(torrent.id, f));
+    row.append(cell);
+    files.append(row);
+  }
+}
+
+function peerFlags(peer) {
+  const flags = [];
+  if (peer.encrypted) flags.push("encrypted");
+  if (peer.encrypted === null) flags.push("encryption unknown");
+  if (peer.choked) flags.push("choked");
+  if (peer.interested) flags.push("interested");
+  if (peer.seed) flags.push("seed");
+  return flags.join(", ") || "-";
+}
+
+function renderPeers(peers, encryption) {
+  const body = document.getElementById("peers");
+  body.replaceChildren();
+  document.getElementById("no-peers").hidden = peers.length > 0;
+
+  const encrypted = peers.filter((p) => p.encrypted).length;
+  const unknown = peers.filter((p) => p.encrypted === null).length;
+  document.getElementById("encryption").textContent =
+    `Encryption: ${encryption}, ${encrypted} of ${peers.length} peers encrypted` +
+    (unknown ? `, ${unknown} unknown` : "");
+
+  for (const p of peers) {
+    const row = document.createElement("tr");
+    const cells = [
+      p.address,
+      p.client || "-",
+      p.connection,
+      peerFlags(p),
+      `${formatBytes(Math.round(p.download_rate))}/s`,
+      `~${formatBytes(Math.round(p.estimated_upload_rate))}/s`,
+      `${p.progress.toFixed(1)}%`,
+    ];
+    for (const value of cells) {
+      const cell = document.createElement("td");
+      cell.textContent = value;
+      row.append(cell);
+    }
+    body.append(row);
+  }
+}
+
+function nextAnnounce(tracker) {
+  if (tracker.status === "announcing") {
+    return "now";
+  }
+  const seconds = Math.round((Date.parse(tracker.next_announce) - Date.now()) / 1000);
+  if (!(seconds > 0)) {
+    return "-";
+  }
+  return seconds >= 60 ? `${Math.floor(seconds / 60)}m${String(seconds % 60).padStart(2, "0")}s` : `${seconds}s`;
+}
+
+function renderTrackers(trackers) {
+  const body = document.getElementById("trackers");
+  body.replaceChildren();
+
+  for (const t of trackers) {
+    const row = document.createElement("tr");
+    const cells = [
+      String(t.tier),
+      t.url,
+      t.status,
+      String(t.seeders),
+      String(t.leechers),
+      nextAnnounce(t),
+      t.last_error || "-",
+    ];
+    for (const value of cells) {
+      const cell = document.createElement("td");
+      cell.textContent = value;
+      row.append(cell);
+    }
+    body.append(row);
+  }
+}
+
+document.getElementById("tracker-form").addEventListener("submit", async (event) => {
+  event.preventDefault();
+  const input = document.getElementById("tracker-url");
+  try {
+    const body = JSON.stringify({ trackers: [input.value.trim()] });
+    await api("POST", `/api/torrents/${selectedID}/trackers`, body);
+    input.value = "";
+    loadDetails();
+  } catch (err) {
+    showMessage(err.message, true);
+  }
+});
+
+document.getElementById("magnet-form").addEventListener("submit", async (event) => {
+  event.preventDefault();
+  const input = document.getElementById("magnet");
+  try {
+    const category = document.getElementById("category").value.trim();
+    const torrent = await api("POST", "/api/torrents", JSON.stringify({ link: input.value.trim(), category }));
+    input.value = "";
+    showMessage(`Added ${torrent.name || torrent.id}`, false);
+  } catch (err) {
+    showMessage(err.message, true);
+  }
+});
+
+document.getElementById("torrent-file").addEventListener("change", async (event) => {
+  const file = event.target.files[0];
+  if (!file) {
+    return;
+  }
+
+  const form = new FormData();
+  form.append("file", file);
+  form.append("category", document.getElementById("category").value.trim());
+  try {
+    const torrent = await api("POST", "/api/torrents/upload", form);
+    showMessage(`Added ${torrent.name || torrent.id}`, false);
+  } catch (err) {
+    showMessage(err.message, true);
+  }
+  event.target.value = "";
+});
+
+function connect() {
+  const events = new EventSource("/api/events");
+  events.addEventListener("open", () => {
+    connection.textContent = "online";
+    connection.className = "online";
+  });
+  events.addEventListener("torrents", (event) => {
+    renderTorrents(JSON.parse(event.data));
+    loadDetails();
+    loadStats();
+  });
+  events.addEventListener("error", () => {
+    connection.textContent = "offline";
+    connection.className = "offline";
+  });
+}
+
+connect();
@@ -0,0 +1,418 @@
+package downloader
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/alucod3/gorrent/internal/config"
+	"github.com/anacrolix/torrent/iplist"
+)
+
+// blocklistTimeout limita o download de uma lista de bloqueio
+const blocklistTimeout = 2 * time.Minute
+
+// BlocklistStatus resume as listas de bloqueio carregadas
+type BlocklistStatus struct {
+	Sources int   `json:"sources"`
+	Ranges  int   `json:"ranges"`
+	Refused int64 `json:"refused"`
+	// Skipped conta as linhas das listas que não puderam ser lidas
+	Skipped int       `json:"skipped"`
+	Updated time.Time `json:"updated"`
+	Error   string    `json:"error,omitempty"`
+}
+
+// blocklist guarda as faixas de IP com as quais o cliente não se conecta. O
+// cliente a consulta ao receber o endereço de um peer e antes de cada conexão,
+// o que permite contar as recusas e trocar as faixas ao recarregar as listas
+// sem recriá-lo.
+type blocklist struct {
+	config  *config.Config
+	http    *http.Client
+	refused atomic.Int64
+
+	mu     sync.RWMutex
+	v4, v6 []iplist.Range
+	// sources guarda as faixas de cada lista, para manter as de uma lista
+	// que falhar ao recarregar
+	sources map[string][]iplist.Range
+	// skipped guarda quantas linhas de cada lista foram ignoradas
+	skipped map[string]int
+	updated time.Time
+	err     error
+}
+
+// dhtBlocklist consulta as faixas sem contar recusas. O DHT consulta a lista
+// a cada pacote recebido, o que não é uma tentativa de conexão com um peer.
+type dhtBlocklist struct {
+	*blocklist
+}
+
+// Lookup retorna a faixa bloqueada que contém ip
+func (b dhtBlocklist) Lookup(ip net.IP) (iplist.Range, bool) {
+	return b.lookup(ip)
+}
+
+// newBlocklist carrega as listas configuradas; sem nenhuma, retorna nil. Uma
+// lista que não carregar fica de fora e o erro aparece nas estatísticas.
+func newBlocklist(cfg *config.Config) (*blocklist, error) {
+	if len(cfg.Blocklists) == 0 {
+		return nil, nil
+	}
+	client, err := proxiedHTTPClient(cfg, blocklistTimeout)
+	if err != nil {
+		return nil, err
+	}
+
+	b := &blocklist{
+		config:  cfg,
+		http:    client,
+		sources: make(map[string][]iplist.Range),
+		skipped: make(map[string]int),
+	}
+	b.reload()
+	return b, nil
+}
+
+// Lookup retorna a faixa bloqueada que contém ip, contando a recusa
+func (b *blocklist) Lookup(ip net.IP) (iplist.Range, bool) {
+	r, ok := b.lookup(ip)
+	if ok {
+		b.refused.Add(1)
+	}
+	return r, ok
+}
+
+// lookup retorna a faixa bloqueada que contém ip
+func (b *blocklist) lookup(ip net.IP) (iplist.Range, bool) {
+	b.mu.RLock()
+	ranges := b.v6
+	if ip4 := ip.To4(); ip4 != nil {
+		ranges, ip = b.v4, ip4
+	}
+	b.mu.RUnlock()
+
+	// A primeira faixa que termina depois de ip é a única que pode contê-lo
+	i := sort.Search(len(ranges), func(i int) bool {
+		return bytes.Compare(ranges[i].Last, ip) >= 0
+	})
+	if i == len(ranges) || bytes.Compare(ranges[i].First, ip) > 0 {
+		return iplist.Range{}, false
+	}
+	return ranges[i], true
+}
+
+// NumRanges retorna quantas faixas estão bloqueadas
+func (b *blocklist) NumRanges() int {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return len(b.v4) + len(b.v6)
+}
+
+// status resume a lista para as estatísticas; b pode ser nil
+func (b *blocklist) status() *BlocklistStatus {
+	if b == nil {
+		return nil
+	}
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	st := &BlocklistStatus{
+		Sources: len(b.config.Blocklists),
+		Ranges:  len(b.v4) + len(b.v6),
+		Refused: b.refused.Load(),
+		Updated: b.updated,
+	}
+	for _, source := range b.config.Blocklists {
+		st.Skipped += b.skipped[source]
+	}
+	if b.err != nil {
+		st.Error = b.err.Error()
+	}
+	return st
+}
+
+// reload lê todas as listas novamente. Uma lista que falhar mantém as faixas
+// que tinha, e as demais são atualizadas mesmo assim.
+func (b *blocklist) reload() error {
+	var errs []error
+	loaded := make(map[string][]iplist.Range, len(b.config.Blocklists))
+	skipped := make(map[string]int, len(b.config.Blocklists))
+	for _, source := range b.config.Blocklists {
+		r, n, err := b.load(source)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("erro ao carregar a lista de bloqueio %s: %w", source, err))
+			continue
+		}
+		loaded[source], skipped[source] = r, n
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	var ranges []iplist.Range
+	for _, source := range b.config.Blocklists {
+		if r, ok := loaded[source]; ok {
+			b.sources[source] = r
+			b.skipped[source] = skipped[source]
+		}
+		ranges = append(ranges, b.sources[source]...)
+	}
+	b.v4, b.v6 = splitRanges(ranges)
+	if len(loaded) > 0 {
+		b.updated = time.Now()
+	}
+	b.err = errors.Join(errs...)
+	return b.err
+}
+
+// load lê as faixas de um arquivo local ou de uma URL e conta as linhas ignoradas
+func (b *blocklist) load(source string) ([]iplist.Range, int, error) {
+	var r io.ReadCloser
+	var err error
+	if strings.HasPrefix(source, "http://") || strings.HasPrefix(source, "https://") {
+		r, err = b.fetch(source)
+	} else {
+		r, err = os.Open(source)
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+	defer r.Close()
+	return parseBlocklist(r)
+}
+
+// fetch baixa uma lista, guardando uma cópia para quando a URL estiver fora do ar
+func (b *blocklist) fetch(source string) (io.ReadCloser, error) {
+	sum := sha256.Sum256([]byte(source))
+	cache := filepath.Join(b.config.BlocklistCacheDir(), hex.EncodeToString(sum[:8]))
+
+	data, err := b.download(source)
+	if err != nil {
+		if f, cacheErr := os.Open(cache); cacheErr == nil {
+			return f, nil
+		}
+		return nil, err
+	}
+
+	if err := os.MkdirAll(b.config.BlocklistCacheDir(), 0755); err == nil {
+		tmp := cache + ".tmp"
+		if os.WriteFile(tmp, data, 0644) == nil {
+			os.Rename(tmp, cache)
+		}
+	}
+	return io.NopCloser(bytes.NewReader(data)), nil
+}
+
+// download retorna o conteúdo de uma URL
+func (b *blocklist) download(source string) ([]byte, error) {
+	resp, err := b.http.Get(source)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("resposta inesperada: %s", resp.Status)
+	}
+	return io.ReadAll(resp.Body)
+}
+
+// parseBlocklist lê uma lista no formato P2P, DAT ou CIDR, compactada ou não
+// com gzip, e retorna também quantas linhas foram ignoradas por não serem
+// faixas válidas. Listas grandes costumam ter algumas linhas quebradas, que
+// não devem derrubar as demais; só uma lista sem nenhuma faixa válida, como
+// uma página de erro no lugar do arquivo, é recusada.
+func parseBlocklist(r io.Reader) ([]iplist.Range, int, error) {
+	br := bufio.NewReader(r)
+	if magic, _ := br.Peek(2); len(magic) == 2 && magic[0] == 0x1f && magic[1] == 0x8b {
+		gz, err := gzip.NewReader(br)
+		if err != nil {
+			return nil, 0, err
+		}
+		defer gz.Close()
+		br = bufio.NewReader(gz)
+	}
+
+	var ranges []iplist.Range
+	var skipped int
+	var firstErr error
+	scanner := bufio.NewScanner(br)
+	scanner.Buffer(make([]byte, 0, 64<<10), 1<<20)
+	for line := 1; scanner.Scan(); line++ {
+		r, ok, err := parseBlocklistLine(scanner.Text())
+		if err != nil {
+			if firstErr == nil {
+				firstErr = fmt.Errorf("linha %d: %w", line, err)
+			}
+			skipped++
+			continue
+		}
+		if ok {
+			ranges = append(ranges, r)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, skipped, err
+	}
+	if len(ranges) == 0 && firstErr != nil {
+		return nil, skipped, fmt.Errorf("nenhuma faixa válida, %d linhas ignoradas (%w)", skipped, firstErr)
+	}
+	return ranges, skipped, nil
+}
+
+// parseBlocklistLine lê uma faixa de IPs em um dos formatos:
+//
+//	P2P:  descrição:1.2.3.0-1.2.3.255
+//	DAT:  001.002.003.000 - 001.002.003.255 , 000 , descrição
+//	CIDR: 1.2.3.0/24 ou um IP isolado
+//
+// Linhas vazias, comentários e faixas que o DAT marca como permitidas são
+// ignorados sem erro.
+func parseBlocklistLine(line string) (iplist.Range, bool, error) {
+	line = strings.TrimSpace(line)
+	if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, "//") {
+		return iplist.Range{}, false, nil
+	}
+
+	if fields := strings.SplitN(line, ",", 3); len(fields) >= 2 {
+		if first, last, ok := strings.Cut(fields[0], "-"); ok {
+			if r, err := ipRange(first, last); err == nil {
+				level, err := strconv.Atoi(strings.TrimSpace(fields[1]))
+				if err != nil {
+					return iplist.Range{}, false, fmt.Errorf("nível de acesso inválido %q", fields[1])
+				}
+				// No formato DAT, só os níveis abaixo de 128 bloqueiam
+				if level >= 128 {
+					return iplist.Range{}, false, nil
+				}
+				if len(fields) == 3 {
+					r.Description = strings.TrimSpace(fields[2])
+				}
+				return r, true, nil
+			}
+		}
+	}
+
+	if prefix, err := netip.ParsePrefix(line); err == nil {
+		return prefixRange(prefix), true, nil
+	}
+	if addr, err := parseBlocklistIP(line); err == nil {
+		return iplist.Range{First: addr.AsSlice(), Last: addr.AsSlice()}, true, nil
+	}
+
+	colon := strings.LastIndex(line, ":")
+	if colon == -1 {
+		return iplist.Range{}, false, fmt.Errorf("formato desconhecido: %q", line)
+	}
+	first, last, ok := strings.Cut(line[colon+1:], "-")
+	if !ok {
+		return iplist.Range{}, false, fmt.Errorf("formato desconhecido: %q", line)
+	}
+	r, err := ipRange(first, last)
+	if err != nil {
+		return iplist.Range{}, false, err
+	}
+	r.Description = line[:colon]
+	return r, true, nil
+}
+
+// ipRange monta a faixa entre dois IPs da mesma família
+func ipRange(first, last string) (iplist.Range, error) {
+	a, err := parseBlocklistIP(first)
+	if err != nil {
+		return iplist.Range{}, err
+	}
+	b, err := parseBlocklistIP(last)
+	if err != nil {
+		return iplist.Range{}, err
+	}
+	if a.Is4() != b.Is4() || b.Less(a) {
+		return iplist.Range{}, fmt.Errorf("faixa de IPs inválida %s-%s", a, b)
+	}
+	return iplist.Range{First: a.AsSlice(), Last: b.AsSlice()}, nil
+}
+
+// parseBlocklistIP lê um IP, aceitando os zeros à esquerda comuns nas listas DAT
+func parseBlocklistIP(s string) (netip.Addr, error) {
+	s = strings.TrimSpace(s)
+	if parts := strings.Split(s, "."); len(parts) == 4 && !strings.Contains(s, ":") {
+		for i, p := range parts {
+			n, err := strconv.ParseUint(p, 10, 8)
+			if err != nil {
+				return netip.Addr{}, fmt.Errorf("IP inválido %q", s)
+			}
+			parts[i] = strconv.FormatUint(n, 10)
+		}
+		s = strings.Join(parts, ".")
+	}
+	addr, err := netip.ParseAddr(s)
+	if err != nil {
+		return netip.Addr{}, fmt.Errorf("IP inválido %q", s)
+	}
+	return addr.Unmap(), nil
+}
+
+// prefixRange retorna o primeiro e o último endereço de um bloco CIDR
+func prefixRange(prefix netip.Prefix) iplist.Range {
+	addr := prefix.Addr().Unmap()
+	bits := prefix.Bits()
+	if prefix.Addr().Is4In6() {
+		bits = max(0, bits-96)
+	}
+	first := netip.PrefixFrom(addr, bits).Masked().Addr().AsSlice()
+	last := slices.Clone(first)
+	for i := bits; i < len(last)*8; i++ {
+		last[i/8] |= 0x80 >> (i % 8)
+	}
+	return iplist.Range{First: first, Last: last}
+}
+
+// splitRanges separa as faixas por família, ordenadas e sem sobreposições,
+// como a busca em Lookup exige
+func splitRanges(ranges []iplist.Range) (v4, v6 []iplist.Range) {
+	for _, r := range ranges {
+		if len(r.First) == net.IPv4len {
+			v4 = append(v4, r)
+		} else {
+			v6 = append(v6, r)
+		}
+	}
+	return mergeRanges(v4), mergeRanges(v6)
+}
+
+// mergeRanges ordena as faixas e junta as que se sobrepõem
+func mergeRanges(ranges []iplist.Range) []iplist.Range {
+	slices.SortFunc(ranges, func(a, b iplist.Range) int {
+		return bytes.Compare(a.First, b.First)
+	})
+
+	var merged []iplist.Range
+	for _, r := range ranges {
+		if n := len(merged); n > 0 && bytes.Compare(r.First, merged[n-1].Last) <= 0 {
+			if bytes.Compare(r.Last, merged[n-1].Last) > 0 {
+				merged[n-1].Last = r.Last
+			}
+			continue
+		}
+		merged = append(merged, r)
+	}
+	return merged
+}
@@ -0,0 +1,196 @@
+package downloader
+
+import (
+	"bytes"
+	"compress/gzip"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/alucod3/gorrent/internal/config"
+	"github.com/anacrolix/torrent/iplist"
+)
+
+func TestParseBlocklistLine(t *testing.T) {
+	tests := []struct {
+		name        string
+		line        string
+		first, last string
+		description string
+		skip        bool
+		err         bool
+	}{
+		{name: "p2p", line: "Alguma rede:1.2.3.0-1.2.3.255", first: "1.2.3.0", last: "1.2.3.255", description: "Alguma rede"},
+		{name: "p2p com dois pontos na descrição", line: "Rede: filial:10.0.0.1-10.0.0.9", first: "10.0.0.1", last: "10.0.0.9", description: "Rede: filial"},
+		{name: "dat", line: "001.002.003.000 - 001.002.003.255 , 000 , Alguma rede", first: "1.2.3.0", last: "1.2.3.255", description: "Alguma rede"},
+		{name: "dat sem descrição", line: "010.000.000.000 - 010.255.255.255 , 100", first: "10.0.0.0", last: "10.255.255.255"},
+		{name: "dat permitido", line: "001.002.003.000 - 001.002.003.255 , 200 , Liberada", skip: true},
+		{name: "cidr", line: "192.168.0.0/16", first: "192.168.0.0", last: "192.168.255.255"},
+		{name: "cidr fora do início", line: "192.168.1.7/24", first: "192.168.1.0", last: "192.168.1.255"},
+		{name: "cidr ipv6", line: "2001:db8::/32", first: "2001:db8::", last: "2001:db8:ffff:ffff:ffff:ffff:ffff:ffff"},
+		{name: "ip isolado", line: "  8.8.8.8  ", first: "8.8.8.8", last: "8.8.8.8"},
+		{name: "vazia", line: "   ", skip: true},
+		{name: "comentário", line: "# lista de teste", skip: true},
+		{name: "comentário com barras", line: "// lista de teste", skip: true},
+		{name: "texto", line: "<html>", err: true},
+		{name: "faixa invertida", line: "Rede:1.2.3.255-1.2.3.0", err: true},
+		{name: "famílias diferentes", line: "Rede:1.2.3.0-::1", err: true},
+		{name: "octeto inválido", line: "Rede:1.2.3.256-1.2.3.300", err: true},
+		{name: "nível inválido", line: "001.002.003.000 - 001.002.003.255 , x , Rede", err: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, ok, err := parseBlocklistLine(tt.line)
+			if tt.err {
+				if err == nil {
+					t.Fatalf("%q aceita como %v", tt.line, r)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if ok == tt.skip {
+				t.Fatalf("%q: faixa %v, esperado ignorar %v", tt.line, ok, tt.skip)
+			}
+			if tt.skip {
+				return
+			}
+			if !r.First.Equal(net.ParseIP(tt.first)) || !r.Last.Equal(net.ParseIP(tt.last)) {
+				t.Errorf("faixa %s-%s, esperada %s-%s", r.First, r.Last, tt.first, tt.last)
+			}
+			if r.Description != tt.description {
+				t.Errorf("descrição %q, esperada %q", r.Description, tt.description)
+			}
+		})
+	}
+}
+
+const testBlocklist = `# lista de teste
+Rede A:1.2.3.0-1.2.3.255
+linha quebrada
+001.002.004.000 - 001.002.004.255 , 000 , Rede B
+Rede C:1.2.3.300-1.2.3.400
+10.0.0.0/8
+`
+
+func TestParseBlocklist(t *testing.T) {
+	ranges, skipped, err := parseBlocklist(strings.NewReader(testBlocklist))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ranges) != 3 || skipped != 2 {
+		t.Errorf("%d faixas e %d linhas ignoradas, esperadas 3 e 2", len(ranges), skipped)
+	}
+}
+
+func TestParseBlocklistGzip(t *testing.T) {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	gz.Write([]byte(testBlocklist))
+	gz.Close()
+
+	ranges, skipped, err := parseBlocklist(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ranges) != 3 || skipped != 2 {
+		t.Errorf("%d faixas e %d linhas ignoradas, esperadas 3 e 2", len(ranges), skipped)
+	}
+}
+
+// Uma página de erro no lugar da lista não deve apagar as faixas anteriores
+func TestParseBlocklistWithoutRanges(t *testing.T) {
+	_, skipped, err := parseBlocklist(strings.NewReader("<html>\n<body>Not Found</body>\n</html>\n"))
+	if err == nil {
+		t.Fatal("lista sem faixas válidas aceita")
+	}
+	if skipped != 3 {
+		t.Errorf("%d linhas ignoradas, esperadas 3", skipped)
+	}
+
+	ranges, _, err := parseBlocklist(strings.NewReader("# só comentários\n\n"))
+	if err != nil || len(ranges) != 0 {
+		t.Errorf("lista vazia: %d faixas, %v", len(ranges), err)
+	}
+}
+
+func TestSplitRanges(t *testing.T) {
+	var ranges []iplist.Range
+	for _, line := range []string{
+		"A:10.0.0.50-10.0.0.100",
+		"B:10.0.0.0-10.0.0.60",
+		"C:10.0.0.20-10.0.0.30",
+		"D:10.0.1.0-10.0.1.255",
+		"2001:db8::/64",
+		"2001:db8::1",
+	} {
+		r, _, err := parseBlocklistLine(line)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ranges = append(ranges, r)
+	}
+
+	v4, v6 := splitRanges(ranges)
+	want := []string{"10.0.0.0-10.0.0.100", "10.0.1.0-10.0.1.255"}
+	if len(v4) != len(want) {
+		t.Fatalf("faixas IPv4 %v, esperadas %v", v4, want)
+	}
+	for i, r := range v4 {
+		if got := r.First.String() + "-" + r.Last.String(); got != want[i] {
+			t.Errorf("faixa %d: %s, esperada %s", i, got, want[i])
+		}
+	}
+	if len(v6) != 1 || v6[0].Last.String() != "2001:db8::ffff:ffff:ffff:ffff" {
+		t.Errorf("faixas IPv6 %v", v6)
+	}
+
+	b := &blocklist{v4: v4, v6: v6}
+	for ip, blocked := range map[string]bool{
+		"10.0.0.0":        true,
+		"10.0.0.75":       true,
+		"10.0.0.101":      false,
+		"10.0.1.255":      true,
+		"10.0.2.0":        false,
+		"::ffff:10.0.0.1": true,
+		"2001:db8::abcd":  true,
+		"2001:db9::":      false,
+	} {
+		if _, ok := b.Lookup(net.ParseIP(ip)); ok != blocked {
+			t.Errorf("%s: bloqueado %v, esperado %v", ip, ok, blocked)
+		}
+	}
+	if got := b.refused.Load(); got != 5 {
+		t.Errorf("%d recusas contadas, esperadas 5", got)
+	}
+}
+
+// Uma lista que passa a vir sem faixas válidas mantém as que tinha, e as linhas
+// ignoradas aparecem nas estatísticas
+func TestBlocklistReloadSkipped(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "lista.p2p")
+	if err := os.WriteFile(path, []byte(testBlocklist), 0644); err != nil {
+		t.Fatal(err)
+	}
+	b, err := newBlocklist(&config.Config{Blocklists: []string{path}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st := b.status(); st.Ranges != 3 || st.Skipped != 2 || st.Error != "" {
+		t.Fatalf("estatísticas %+v", st)
+	}
+
+	if err := os.WriteFile(path, []byte("<html>\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.reload(); err == nil {
+		t.Fatal("recarregar uma lista sem faixas não falhou")
+	}
+	if st := b.status(); st.Ranges != 3 || st.Skipped != 2 || st.Error == "" {
+		t.Fatalf("estatísticas depois da falha %+v", st)
+	}
+}
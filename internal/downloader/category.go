@@ -0,0 +1,143 @@
+package downloader
+
+import (
+	"fmt"
+	"net/url"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/alucod3/gorrent/internal/config"
+	"github.com/anacrolix/torrent"
+)
+
+// Options são as escolhas feitas ao adicionar um torrent
+type Options struct {
+	Category string `json:"category,omitempty"`
+	// FilePriorities define a prioridade por índice de arquivo; os demais ficam normais
+	FilePriorities map[int]string `json:"file_priorities,omitempty"`
+	// Sequential baixa um arquivo em ordem para que seu início fique utilizável primeiro
+	Sequential bool `json:"sequential,omitempty"`
+	// SequentialFile é o índice do arquivo baixado em ordem; nil escolhe o maior
+	SequentialFile *int `json:"sequential_file,omitempty"`
+	// Trackers são acrescentados aos do torrent
+	Trackers []string `json:"trackers,omitempty"`
+	// WebSeeds são URLs HTTP(S) de onde os dados também podem ser baixados (BEP 19)
+	WebSeeds []string `json:"web_seeds,omitempty"`
+	// Duplicate é o que fazer se o torrent já foi baixado; vazio segue a configuração
+	Duplicate string `json:"duplicate,omitempty"`
+}
+
+// validate verifica as opções antes de adicionar o torrent
+func (o Options) validate(cfg *config.Config) error {
+	if o.Category != "" && cfg.Category(o.Category) == nil {
+		return fmt.Errorf("categoria desconhecida: %s", o.Category)
+	}
+	for _, name := range o.FilePriorities {
+		if err := validatePriority(name); err != nil {
+			return err
+		}
+	}
+	if err := validateTrackers(o.Trackers); err != nil {
+		return err
+	}
+	if err := validateWebSeeds(o.WebSeeds); err != nil {
+		return err
+	}
+	if _, err := duplicateAction(cfg, o.Duplicate); err != nil {
+		return err
+	}
+	if o.SequentialFile != nil {
+		if !o.Sequential {
+			return fmt.Errorf("o arquivo sequencial só vale no modo sequencial")
+		}
+		if o.FilePriorities[*o.SequentialFile] == PrioritySkip {
+			return fmt.Errorf("o arquivo %d não pode ser baixado em ordem e ignorado ao mesmo tempo", *o.SequentialFile)
+		}
+	}
+	return nil
+}
+
+// matchCategory escolhe a categoria de um torrent que já tem metadados: a pedida
+// explicitamente ou a primeira cujas regras combinam. Retorna nil se nenhuma combinar.
+func matchCategory(cfg *config.Config, t *torrent.Torrent, requested string) *config.Category {
+	if requested != "" {
+		return cfg.Category(requested)
+	}
+	for i := range cfg.Categories {
+		if categoryMatches(cfg.Categories[i].Match, t) {
+			return &cfg.Categories[i]
+		}
+	}
+	return nil
+}
+
+// categoryMatches verifica se alguma das regras combina com o torrent
+func categoryMatches(rules config.CategoryRules, t *torrent.Torrent) bool {
+	if rules.NamePattern != "" {
+		// O padrão já foi validado ao carregar a configuração
+		if regexp.MustCompile(rules.NamePattern).MatchString(t.Name()) {
+			return true
+		}
+	}
+
+	if len(rules.TrackerHosts) > 0 {
+		mi := t.Metainfo()
+		for _, tier := range mi.UpvertedAnnounceList() {
+			for _, tracker := range tier {
+				if u, err := url.Parse(tracker); err == nil && hostMatches(u.Hostname(), rules.TrackerHosts) {
+					return true
+				}
+			}
+		}
+	}
+
+	if len(rules.Extensions) > 0 {
+		for _, f := range t.Files() {
+			ext := strings.ToLower(filepath.Ext(f.Path()))
+			for _, want := range rules.Extensions {
+				if ext != "" && ext == "."+strings.TrimPrefix(strings.ToLower(want), ".") {
+					return true
+				}
+			}
+		}
+	}
+
+	return false
+}
+
+// hostMatches verifica se host é um dos hosts informados ou um subdomínio deles
+func hostMatches(host string, hosts []string) bool {
+	host = strings.ToLower(host)
+	for _, h := range hosts {
+		h = strings.ToLower(h)
+		if host == h || strings.HasSuffix(host, "."+h) {
+			return true
+		}
+	}
+	return false
+}
+
+// destinationDir retorna para onde o torrent vai ao terminar
+func destinationDir(cfg *config.Config, c *config.Category) string {
+	if c != nil && c.Path != "" {
+		return c.Path
+	}
+	return cfg.CompletedDir()
+}
+
+// shouldSeed indica se o torrent deve continuar semeando após terminar
+func shouldSeed(cfg *config.Config, c *config.Category) bool {
+	if c != nil && c.Seed != nil {
+		return *c.Seed
+	}
+	return cfg.Seed
+}
+
+// categoryName retorna o nome da categoria, ou vazio se não houver
+func categoryName(c *config.Category) string {
+	if c == nil {
+		return ""
+	}
+	return c.Name
+}
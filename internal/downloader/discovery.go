@@ -0,0 +1,170 @@
+package downloader
+
+import (
+	"net"
+	"slices"
+	"time"
+
+	"github.com/alucod3/gorrent/internal/config"
+	"github.com/anacrolix/dht/v2"
+	"github.com/anacrolix/dht/v2/krpc"
+	"github.com/anacrolix/torrent"
+	pp "github.com/anacrolix/torrent/peer_protocol"
+)
+
+// dhtAnnounceInterval é de quanto em quanto tempo um torrent é anunciado na DHT
+const dhtAnnounceInterval = 5 * time.Minute
+
+// isPrivate indica se o torrent é privado (BEP 27). Sem o metainfo, ainda não há
+// como saber, e o torrent é tratado como público.
+func isPrivate(t *torrent.Torrent) bool {
+	info := t.Info()
+	return info != nil && info.Private != nil && *info.Private
+}
+
+// restrictPrivatePEX impede a troca de peers (PEX) nas conexões de torrents
+// privados. O cliente só permite desligá-la para todos os torrents.
+func restrictPrivatePEX(cb *torrent.Callbacks) {
+	// Não oferecer a extensão aos peers...
+	cb.PeerConnAdded = append(cb.PeerConnAdded, func(pc *torrent.PeerConn) {
+		if !isPrivate(pc.Torrent()) {
+			return
+		}
+		// O mapa é compartilhado entre as conexões, então é copiado
+		local := pc.LocalLtepProtocolMap
+		builtin := slices.DeleteFunc(slices.Clone(local.Index[:local.NumBuiltin]), func(name pp.ExtensionName) bool {
+			return name == pp.ExtensionNamePex
+		})
+		pc.LocalLtepProtocolMap = &torrent.LocalLtepProtocolMap{
+			Index:      append(builtin, local.Index[local.NumBuiltin:]...),
+			NumBuiltin: len(builtin),
+		}
+	})
+	// ...nem usá-la quando eles a oferecem
+	cb.ReadExtendedHandshake = func(pc *torrent.PeerConn, msg *pp.ExtendedHandshakeMessage) {
+		if isPrivate(pc.Torrent()) {
+			delete(msg.M, pp.ExtensionNamePex)
+		}
+	}
+}
+
+// discovery procura peers sem depender de trackers: pela DHT, anunciando cada
+// torrent público, e pela rede local
+type discovery struct {
+	client *torrent.Client
+	config *config.Config
+	lsd    *lsd
+}
+
+// newDiscovery carrega a tabela da DHT salva na execução anterior e começa a
+// descoberta na rede local
+func newDiscovery(client *torrent.Client, cfg *config.Config) *discovery {
+	d := &discovery{client: client, config: cfg}
+	d.loadNodes()
+	// Os anúncios na rede local não passam pelo proxy
+	if !cfg.DisableLSD && !cfg.ProxyPeers {
+		d.lsd = newLSD(client, cfg)
+	}
+	return d
+}
+
+// start passa a anunciar um torrent na DHT e na rede local
+func (d *discovery) start(t *torrent.Torrent) {
+	if !d.config.DisableDHT {
+		go d.announceDHT(t)
+	}
+	if d.lsd != nil && !isPrivate(t) {
+		d.lsd.announce([]*torrent.Torrent{t})
+	}
+}
+
+// close para a descoberta na rede local e salva a tabela da DHT
+func (d *discovery) close() {
+	if d.lsd != nil {
+		d.lsd.close()
+	}
+	d.saveNodes()
+}
+
+// announceDHT anuncia o torrent na DHT periodicamente até ele ser removido ou
+// se descobrir, ao chegar o metainfo, que é privado
+func (d *discovery) announceDHT(t *torrent.Torrent) {
+	for !isPrivate(t) {
+		var stops []func()
+		for _, s := range d.client.DhtServers() {
+			if _, stop, err := t.AnnounceToDht(s); err == nil {
+				stops = append(stops, stop)
+			}
+		}
+
+		gotInfo := t.GotInfo()
+		if t.Info() != nil {
+			gotInfo = nil
+		}
+		timer := time.NewTimer(dhtAnnounceInterval)
+		closed := false
+	wait:
+		for {
+			select {
+			case <-t.Closed():
+				closed = true
+				break wait
+			case <-gotInfo:
+				if isPrivate(t) {
+					break wait
+				}
+				gotInfo = nil
+			case <-timer.C:
+				break wait
+			}
+		}
+
+		timer.Stop()
+		for _, stop := range stops {
+			stop()
+		}
+		if closed {
+			return
+		}
+	}
+}
+
+// loadNodes adiciona à DHT os nós salvos, para não depender só dos nós de
+// bootstrap ao resolver magnets logo após iniciar
+func (d *discovery) loadNodes() {
+	if d.config.DisableDHT {
+		return
+	}
+	nodes, err := dht.ReadNodesFromFile(d.config.DHTNodesPath())
+	if err != nil {
+		return
+	}
+
+	for _, s := range d.client.DhtServers() {
+		addr, ok := s.Addr().(*net.UDPAddr)
+		if !ok {
+			continue
+		}
+		ipv4 := addr.IP.To4() != nil
+		for _, n := range nodes {
+			if (n.Addr.IP.To4() != nil) == ipv4 {
+				s.AddNode(n)
+			}
+		}
+	}
+}
+
+// saveNodes grava os nós conhecidos da DHT para a próxima execução
+func (d *discovery) saveNodes() {
+	var nodes []krpc.NodeInfo
+	for _, s := range d.client.DhtServers() {
+		if w, ok := s.(torrent.AnacrolixDhtServerWrapper); ok {
+			nodes = append(nodes, w.Nodes()...)
+		}
+	}
+	// Uma execução curta ou sem rede não apaga a tabela salva
+	if len(nodes) == 0 || d.config.EnsureStateDir() != nil {
+		return
+	}
+	dht.WriteNodesToFile(nodes, d.config.DHTNodesPath())
+}
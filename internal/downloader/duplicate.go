@@ -0,0 +1,133 @@
+package downloader
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/alucod3/gorrent/internal/config"
+	"github.com/alucod3/gorrent/internal/history"
+	"github.com/anacrolix/torrent"
+)
+
+// O que fazer com um torrent que já foi baixado
+const (
+	DuplicateAsk      = "ask"
+	DuplicateSeed     = "seed"
+	DuplicateSkip     = "skip"
+	DuplicateDownload = "download"
+)
+
+// ErrDuplicate indica que o torrent já foi baixado e não foi adicionado de novo
+var ErrDuplicate = errors.New("torrent já baixado")
+
+// errOccupied avisa antes do download que os dados não poderiam ser movidos
+// para path ao terminar
+func errOccupied(path string) error {
+	return fmt.Errorf("%s já existe no destino e não seria substituído; mova ou remova antes de baixar de novo", path)
+}
+
+// duplicateAction retorna o que fazer com torrents já baixados, pedido nas
+// opções ou definido na configuração
+func duplicateAction(cfg *config.Config, requested string) (string, error) {
+	action := requested
+	if action == "" {
+		action = cfg.DuplicateAction
+	}
+	switch action {
+	case DuplicateAsk, DuplicateSeed, DuplicateSkip, DuplicateDownload:
+		return action, nil
+	case "":
+		return DuplicateAsk, nil
+	default:
+		return "", fmt.Errorf("ação para torrents já baixados inválida: %s (use ask, seed, skip ou download)", action)
+	}
+}
+
+// duplicate descreve um download anterior do mesmo torrent
+type duplicate struct {
+	// entry é o último download concluído no histórico, ou nil se não houver
+	entry *history.Entry
+	// path é onde está a cópia existente dos dados; vazio se não foi encontrada
+	path string
+}
+
+// dir retorna o diretório que contém a cópia existente
+func (d duplicate) dir() string {
+	return filepath.Dir(d.path)
+}
+
+// String descreve o download anterior para o aviso ao usuário
+func (d duplicate) String() string {
+	var parts []string
+	if d.entry != nil {
+		parts = append(parts, fmt.Sprintf("concluído em %s (histórico %d)", d.entry.Finished.Local().Format(time.DateTime), d.entry.ID))
+	}
+	if d.path != "" {
+		parts = append(parts, "cópia em "+d.path)
+	} else if d.entry != nil && d.entry.Path != "" && exists(d.entry.Path) {
+		parts = append(parts, d.entry.Path+" não corresponde mais ao torrent")
+	} else if d.entry != nil && d.entry.Path != "" {
+		parts = append(parts, d.entry.Path+" não existe mais")
+	}
+	return strings.Join(parts, ", ")
+}
+
+// findDuplicate procura um download concluído do torrent no histórico e uma
+// cópia dos dados no destino. No diretório de downloads incompletos, os dados
+// só contam como cópia se o histórico registrar o download como concluído,
+// já que podem ser de um download interrompido.
+func findDuplicate(cfg *config.Config, store *history.Store, t *torrent.Torrent, c *config.Category) (duplicate, bool) {
+	var d duplicate
+
+	// O histórico é só um auxílio; sem ele, ainda vale a cópia no destino
+	entries, _ := store.List()
+	hash := t.InfoHash().HexString()
+	for i := len(entries) - 1; i >= 0; i-- {
+		e := entries[i]
+		if e.Result == history.ResultCompleted && strings.EqualFold(e.InfoHash, hash) {
+			d.entry = &e
+			break
+		}
+	}
+
+	// Só conta como cópia o que tem os mesmos arquivos e tamanhos do torrent,
+	// já que as peças que faltarem serão gravadas ali
+	dst := destinationDir(cfg, c)
+	if path := filepath.Join(dst, t.Name()); dst != cfg.IncompleteDir() && matchesFiles(t, dst) {
+		d.path = path
+	} else if d.entry != nil && d.entry.Path != "" && matchesFiles(t, filepath.Dir(d.entry.Path)) {
+		d.path = d.entry.Path
+	}
+	return d, d.entry != nil || d.path != ""
+}
+
+// matchesFiles indica se dir contém os arquivos do torrent com os tamanhos
+// certos; arquivos vazios podem faltar, já que o armazenamento não os cria
+func matchesFiles(t *torrent.Torrent, dir string) bool {
+	if !exists(filepath.Join(dir, t.Name())) {
+		return false
+	}
+	for _, f := range t.Files() {
+		info, err := os.Stat(filepath.Join(dir, filepath.FromSlash(f.Path())))
+		if err != nil {
+			if f.Length() == 0 && os.IsNotExist(err) {
+				continue
+			}
+			return false
+		}
+		if !info.Mode().IsRegular() || info.Size() != f.Length() {
+			return false
+		}
+	}
+	return true
+}
+
+// exists indica se o arquivo ou diretório existe
+func exists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
@@ -0,0 +1,439 @@
+package downloader
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/alucod3/gorrent/internal/config"
+	"github.com/alucod3/gorrent/internal/hooks"
+	"github.com/alucod3/gorrent/pkg/utils"
+	"github.com/anacrolix/dht/v2"
+	"github.com/anacrolix/log"
+	"github.com/anacrolix/torrent"
+	"github.com/anacrolix/torrent/metainfo"
+	"github.com/anacrolix/torrent/storage"
+)
+
+// Limites ao baixar um .torrent de uma URL
+const (
+	torrentFetchTimeout = time.Minute
+	maxTorrentFileSize  = 10 << 20
+)
+
+// engine agrupa o cliente torrent e os recursos que vivem junto com ele
+type engine struct {
+	config    *config.Config
+	client    *torrent.Client
+	backend   StorageBackend
+	storage   storage.ClientImplCloser
+	throttle  *throttle
+	peers     *peerTracker
+	trackers  *trackerSet
+	discovery *discovery
+	blocklist *blocklist
+	portfwd   *portForwarder
+	webseeds  *webseedCounter
+
+	// stored são os armazenamentos abertos nos diretórios fora do de
+	// incompletos, compartilhados pelos torrents guardados em cada um, e
+	// storedDirs o diretório de cada torrent
+	storedMu   sync.Mutex
+	stored     map[string]*dirStorage
+	storedDirs map[metainfo.Hash]string
+}
+
+// dirStorage é o armazenamento de um diretório e quantos torrents o usam
+type dirStorage struct {
+	impl storage.ClientImplCloser
+	refs int
+}
+
+// newEngine cria um cliente torrent a partir das configurações da aplicação
+func newEngine(cfg *config.Config) (*engine, error) {
+	e := &engine{
+		config:     cfg,
+		throttle:   newThrottle(cfg),
+		peers:      newPeerTracker(cfg.Encryption),
+		webseeds:   newWebseedCounter(),
+		stored:     make(map[string]*dirStorage),
+		storedDirs: make(map[metainfo.Hash]string),
+	}
+
+	backend, err := lookupStorage(cfg.Storage)
+	if err != nil {
+		return nil, err
+	}
+	e.backend = backend
+
+	e.storage, err = backend.Open(cfg.IncompleteDir())
+	if err != nil {
+		return nil, fmt.Errorf("erro ao abrir o armazenamento %s: %w", cfg.Storage, err)
+	}
+
+	clientConfig := torrent.NewDefaultClientConfig()
+	clientConfig.DataDir = cfg.IncompleteDir()
+	// O cliente só preenche o logger na cópia dele, e os avisos dos trackers
+	// e do mapeamento de portas usam o da configuração
+	clientConfig.Logger = log.Default
+	// Torrents que não devem semear têm o envio bloqueado individualmente
+	clientConfig.Seed = cfg.Seed
+	for _, c := range cfg.Categories {
+		if c.Seed != nil && *c.Seed {
+			clientConfig.Seed = true
+		}
+	}
+	clientConfig.DefaultStorage = e.throttle.wrap(e.storage)
+	e.peers.install(&clientConfig.Callbacks)
+	e.webseeds.install(&clientConfig.Callbacks)
+	// Os anúncios HTTP e UDP são feitos pelo trackerSet, que guarda os resultados
+	clientConfig.LookupTrackerIp = skipTrackerLookup
+	// A DHT é anunciada pelo discovery, que deixa de fora os torrents privados
+	clientConfig.NoDHT = cfg.DisableDHT
+	clientConfig.PeriodicallyAnnounceTorrentsToDht = false
+	clientConfig.DisablePEX = cfg.DisablePEX
+	if !cfg.DisablePEX {
+		restrictPrivatePEX(&clientConfig.Callbacks)
+	}
+	if err := configureNetwork(clientConfig, cfg); err != nil {
+		e.storage.Close()
+		return nil, err
+	}
+	peerDialer, err := configureProxy(clientConfig, cfg)
+	if err != nil {
+		e.storage.Close()
+		return nil, err
+	}
+	// O cliente mapeia a porta por UPnP por conta própria, sem mostrar o
+	// resultado; com PortForwarding, o portForwarder faz isso no lugar dele
+	clientConfig.NoDefaultPortForwarding = cfg.PortForwarding || !clientConfig.AcceptPeerConnections
+
+	// As listas de bloqueio precisam valer desde a primeira conexão
+	e.blocklist, err = newBlocklist(cfg)
+	if err != nil {
+		e.storage.Close()
+		return nil, err
+	}
+	if e.blocklist != nil {
+		clientConfig.IPBlocklist = e.blocklist
+		clientConfig.ConfigureAnacrolixDhtServer = func(c *dht.ServerConfig) {
+			c.IPBlocklist = dhtBlocklist{e.blocklist}
+		}
+	}
+
+	client, err := newClient(clientConfig, cfg.ListenPort)
+	if err != nil {
+		e.storage.Close()
+		return nil, fmt.Errorf("erro ao criar cliente torrent: %w", err)
+	}
+	e.client = client
+	// Uma lista fora do ar e sem cópia não impede o download com as demais
+	if st := e.blocklist.status(); st != nil && st.Error != "" {
+		clientConfig.Logger.Levelf(log.Warning, "%s", st.Error)
+	}
+	if st := e.blocklist.status(); st != nil && st.Skipped > 0 {
+		clientConfig.Logger.Levelf(log.Warning, "%d linhas das listas de bloqueio foram ignoradas por não serem faixas de IP válidas", st.Skipped)
+	}
+	if e.throttle.limitsUpload() {
+		go e.throttle.run(client)
+	}
+	if peerDialer != nil {
+		client.AddDialer(peerDialer)
+	}
+	e.trackers = newTrackerSet(client, clientConfig, cfg.ProxyPeers)
+	e.discovery = newDiscovery(client, cfg)
+	// Sem conexões de entrada, como ao usar o proxy para os peers, não há o que mapear
+	if cfg.PortForwarding && clientConfig.AcceptPeerConnections {
+		e.portfwd = newPortForwarder(client.LocalPort(), clientConfig.Logger)
+	}
+
+	return e, nil
+}
+
+// Close encerra o cliente torrent e o armazenamento
+func (e *engine) Close() {
+	e.throttle.close()
+	e.trackers.close()
+	e.discovery.close()
+	if e.portfwd != nil {
+		e.portfwd.close()
+	}
+	e.client.Close()
+	e.storage.Close()
+	for _, ds := range e.stored {
+		ds.impl.Close()
+	}
+}
+
+// add adiciona um torrent ao cliente baseado no tipo de entrada (arquivo local, magnet, etc)
+func (e *engine) add(link string) (*torrent.Torrent, error) {
+	if _, err := os.Stat(link); err == nil {
+		// É um arquivo local
+		mi, err := metainfo.LoadFromFile(link)
+		if err != nil {
+			return nil, err
+		}
+		return e.addMetaInfo(mi)
+	} else if strings.HasPrefix(link, "magnet:") {
+		// É um magnet link
+		spec, err := torrent.TorrentSpecFromMagnetUri(link)
+		if err != nil {
+			return nil, err
+		}
+		if spec.InfoHash == (metainfo.Hash{}) && !spec.InfoHashV2.Ok {
+			return nil, errors.New("magnet link sem info hash btih ou btmh")
+		}
+		v2Only := isV2Only(spec)
+		t, err := e.addSpec(spec)
+		if err != nil {
+			return nil, err
+		}
+		if v2Only {
+			go reconnectV2(t)
+		}
+		// Magnets costumam trazer poucos trackers, então os padrões são acrescentados
+		e.trackers.start(t)
+		if err := e.trackers.add(t, e.config.DefaultTrackers); err != nil {
+			e.trackers.stop(t.InfoHash())
+			t.Drop()
+			return nil, err
+		}
+		e.discovery.start(t)
+		e.limitConns()
+		return t, nil
+	} else if strings.HasPrefix(link, "http://") || strings.HasPrefix(link, "https://") {
+		// É uma URL de um arquivo .torrent
+		mi, err := e.fetchTorrent(link)
+		if err != nil {
+			return nil, err
+		}
+		return e.addMetaInfo(mi)
+	} else {
+		// URL não suportada
+		return nil, fmt.Errorf("link não suportado: use um magnet link, um arquivo .torrent local ou uma URL http(s) de .torrent")
+	}
+}
+
+// fetchTorrent baixa um arquivo .torrent pelo proxy configurado, se houver
+func (e *engine) fetchTorrent(link string) (*metainfo.MetaInfo, error) {
+	client, err := proxiedHTTPClient(e.config, torrentFetchTimeout)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := client.Get(link)
+	if err != nil {
+		return nil, fmt.Errorf("erro ao baixar o .torrent: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("erro ao baixar o .torrent: resposta inesperada: %s", resp.Status)
+	}
+
+	mi, err := metainfo.Load(io.LimitReader(resp.Body, maxTorrentFileSize))
+	if err != nil {
+		return nil, fmt.Errorf("arquivo .torrent inválido em %s: %w", link, err)
+	}
+	return mi, nil
+}
+
+// addMetaInfo adiciona um torrent a partir do conteúdo de um arquivo .torrent
+func (e *engine) addMetaInfo(mi *metainfo.MetaInfo) (*torrent.Torrent, error) {
+	spec, err := torrent.TorrentSpecFromMetaInfoErr(mi)
+	if err != nil {
+		return nil, err
+	}
+	t, err := e.addSpec(spec)
+	if err != nil {
+		return nil, err
+	}
+	e.trackers.start(t)
+	e.discovery.start(t)
+	e.limitConns()
+	return t, nil
+}
+
+// remove para de anunciar um torrent e o retira do cliente
+func (e *engine) remove(t *torrent.Torrent) {
+	e.trackers.stop(t.InfoHash())
+	e.drop(t)
+	e.limitConns()
+}
+
+// drop retira o torrent do cliente, fechando o armazenamento do diretório em
+// que ele estava guardado se nenhum outro torrent o usa
+func (e *engine) drop(t *torrent.Torrent) {
+	t.Drop()
+	e.webseeds.forget(t)
+	e.peers.forget(t)
+	e.throttle.forget(t)
+	e.releaseStored(t.InfoHash())
+}
+
+// moveCompleted move os dados de um torrent concluído para dst. Com reseed, o
+// torrent é adicionado novamente apontando para o novo local, para continuar
+// semeando, e cabe a quem chama aplicar as prioridades dos arquivos; caso
+// contrário é apenas removido do cliente. Backends que não gravam arquivos
+// comuns mantêm os dados onde estão.
+func (e *engine) moveCompleted(t *torrent.Torrent, dst string, reseed bool) (*torrent.Torrent, error) {
+	if e.config.IncompleteDir() == dst || !e.backend.PlainFiles() {
+		return t, nil
+	}
+
+	mi := t.Metainfo()
+	name := t.Name()
+	if len(mi.PieceLayers) == 0 {
+		// Um mapa vazio faz torrents v1 serem tratados como tendo camadas v2 ausentes
+		mi.PieceLayers = nil
+	}
+
+	// Fechar os arquivos antes de movê-los; sem semear, o torrent sai dos trackers
+	if !reseed {
+		e.trackers.stop(t.InfoHash())
+	}
+	e.drop(t)
+
+	src := filepath.Join(e.config.IncompleteDir(), name)
+	if err := utils.MovePath(src, filepath.Join(dst, name)); err != nil {
+		return nil, fmt.Errorf("erro ao mover download concluído: %w", err)
+	}
+
+	if !reseed {
+		e.limitConns()
+		return nil, nil
+	}
+
+	moved, err := e.addStored(&mi, dst)
+	if err != nil {
+		return nil, fmt.Errorf("erro ao semear a partir do novo local: %w", err)
+	}
+	return moved, nil
+}
+
+// occupied retorna o caminho em dst para onde os dados de t seriam movidos ao
+// terminar, se já houver algo lá; como nada é sobrescrito, a movimentação falharia
+func (e *engine) occupied(t *torrent.Torrent, dst string) (string, bool) {
+	if e.config.IncompleteDir() == dst || !e.backend.PlainFiles() {
+		return "", false
+	}
+	path := filepath.Join(dst, t.Name())
+	return path, exists(path)
+}
+
+// useExisting troca o torrent por um que usa a cópia dos dados em dir; as
+// peças são verificadas e o que faltar é baixado lá mesmo. Armazenamentos que
+// não guardam arquivos comuns não leem a cópia, e o torrent continua como está.
+func (e *engine) useExisting(t *torrent.Torrent, dir string) (*torrent.Torrent, error) {
+	if !e.backend.PlainFiles() {
+		return t, nil
+	}
+
+	mi := t.Metainfo()
+	if len(mi.PieceLayers) == 0 {
+		mi.PieceLayers = nil
+	}
+	e.drop(t)
+
+	existing, err := e.addStored(&mi, dir)
+	if err != nil {
+		return nil, fmt.Errorf("erro ao usar a cópia existente: %w", err)
+	}
+	// A cópia pode ter mudado desde que as peças concluídas foram registradas
+	// no diretório, então é verificada de novo
+	go existing.VerifyData()
+	return existing, nil
+}
+
+// addStored adiciona o torrent com os dados guardados em dir, usando o
+// backend configurado. O registro das peças concluídas fica no próprio
+// diretório, então elas só são verificadas na primeira vez que o torrent é
+// adicionado lá.
+func (e *engine) addStored(mi *metainfo.MetaInfo, dir string) (*torrent.Torrent, error) {
+	spec, err := torrent.TorrentSpecFromMetaInfoErr(mi)
+	if err != nil {
+		return nil, err
+	}
+	// O mesmo info hash que o cliente usa, que em torrents só v2 é o v2 truncado
+	ih := spec.InfoHash
+	if isV2Only(spec) {
+		ih = *spec.InfoHashV2.Value.ToShort()
+	}
+	if dir != e.config.IncompleteDir() {
+		impl, err := e.openStored(ih, dir)
+		if err != nil {
+			return nil, err
+		}
+		spec.Storage = e.throttle.wrap(impl)
+	}
+
+	t, err := e.addSpec(spec)
+	if err != nil {
+		e.releaseStored(ih)
+		return nil, err
+	}
+	// Um torrent readicionado mantém os anúncios que já tinha; os demais, como
+	// os restaurados da sessão, passam a ser anunciados
+	e.trackers.start(t)
+	e.discovery.start(t)
+	e.limitConns()
+	return t, nil
+}
+
+// openStored abre o armazenamento de dir para um torrent, ou reaproveita o
+// que já está aberto: o registro das peças concluídas não pode ser aberto duas
+// vezes
+func (e *engine) openStored(ih metainfo.Hash, dir string) (storage.ClientImpl, error) {
+	e.storedMu.Lock()
+	defer e.storedMu.Unlock()
+
+	ds := e.stored[dir]
+	if ds == nil {
+		impl, err := e.backend.Open(dir)
+		if err != nil {
+			return nil, fmt.Errorf("erro ao abrir o armazenamento em %s: %w", dir, err)
+		}
+		ds = &dirStorage{impl: impl}
+		e.stored[dir] = ds
+	}
+	ds.refs++
+	e.storedDirs[ih] = dir
+	return ds.impl, nil
+}
+
+// releaseStored deixa de usar o armazenamento do diretório do torrent,
+// fechando-o quando nenhum outro torrent o usa
+func (e *engine) releaseStored(ih metainfo.Hash) {
+	e.storedMu.Lock()
+	defer e.storedMu.Unlock()
+
+	dir, ok := e.storedDirs[ih]
+	if !ok {
+		return
+	}
+	delete(e.storedDirs, ih)
+	if ds := e.stored[dir]; ds != nil {
+		if ds.refs--; ds.refs <= 0 {
+			ds.impl.Close()
+			delete(e.stored, dir)
+		}
+	}
+}
+
+// payloadFor monta os dados do torrent enviados aos hooks, sem o evento
+func payloadFor(t *torrent.Torrent, dir string) hooks.Payload {
+	p := hooks.Payload{
+		Name:     t.Name(),
+		InfoHash: t.InfoHash().HexString(),
+		Path:     dir,
+	}
+	if t.Info() != nil {
+		p.Path = filepath.Join(dir, t.Name())
+		p.Size = t.Length()
+	}
+	return p
+}
@@ -0,0 +1,121 @@
+package downloader
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/anacrolix/torrent"
+	"github.com/anacrolix/torrent/types"
+)
+
+// Prioridades de arquivo aceitas
+const (
+	PrioritySkip   = "skip"
+	PriorityNormal = "normal"
+	PriorityHigh   = "high"
+	PriorityNow    = "now"
+)
+
+// filePriorities relaciona os nomes das prioridades com as do cliente
+var filePriorities = map[string]types.PiecePriority{
+	PrioritySkip:   types.PiecePriorityNone,
+	PriorityNormal: types.PiecePriorityNormal,
+	PriorityHigh:   types.PiecePriorityHigh,
+	PriorityNow:    types.PiecePriorityNow,
+}
+
+// validatePriority verifica se o nome da prioridade é conhecido
+func validatePriority(name string) error {
+	if _, ok := filePriorities[name]; !ok {
+		return fmt.Errorf("prioridade inválida %q: use skip, normal, high ou now", name)
+	}
+	return nil
+}
+
+// priorityName retorna o nome de uma prioridade do cliente
+func priorityName(p types.PiecePriority) string {
+	switch {
+	case p == types.PiecePriorityNone:
+		return PrioritySkip
+	case p >= types.PiecePriorityNow:
+		return PriorityNow
+	case p >= types.PiecePriorityHigh:
+		return PriorityHigh
+	default:
+		return PriorityNormal
+	}
+}
+
+// maxFileIndex limita os intervalos aceitos, para que um intervalo como
+// 0-999999999 não crie uma entrada por índice; o número real de arquivos só é
+// conferido ao aplicar as prioridades
+const maxFileIndex = 1 << 20
+
+// ParseFilePriorities interpreta especificações como "0,2-4=skip" e as
+// acrescenta em priorities, indexadas pelo número do arquivo. Uma
+// especificação inválida não altera priorities.
+func ParseFilePriorities(spec string, priorities map[int]string) error {
+	indexes, name, ok := strings.Cut(spec, "=")
+	if !ok {
+		return fmt.Errorf("prioridade inválida %q: use ARQUIVOS=PRIORIDADE, por exemplo 0,2-4=skip", spec)
+	}
+
+	name = strings.ToLower(strings.TrimSpace(name))
+	if err := validatePriority(name); err != nil {
+		return err
+	}
+
+	var parsed []int
+	for _, part := range strings.Split(indexes, ",") {
+		first, last, isRange := strings.Cut(strings.TrimSpace(part), "-")
+		begin, err := strconv.Atoi(strings.TrimSpace(first))
+		if err != nil || begin < 0 || begin > maxFileIndex {
+			return fmt.Errorf("índice de arquivo inválido %q", part)
+		}
+		end := begin
+		if isRange {
+			if end, err = strconv.Atoi(strings.TrimSpace(last)); err != nil || end < begin || end > maxFileIndex {
+				return fmt.Errorf("intervalo de arquivos inválido %q", part)
+			}
+		}
+		for i := begin; i <= end; i++ {
+			parsed = append(parsed, i)
+		}
+	}
+	for _, i := range parsed {
+		priorities[i] = name
+	}
+	return nil
+}
+
+// applyPriorities define a prioridade de cada arquivo; os não listados ficam normais
+func applyPriorities(t *torrent.Torrent, priorities map[int]string) error {
+	files := t.Files()
+	for index := range priorities {
+		if index >= len(files) {
+			return fmt.Errorf("o torrent tem %d arquivos, índice %d não existe", len(files), index)
+		}
+	}
+
+	for i, f := range files {
+		name, ok := priorities[i]
+		if !ok {
+			name = PriorityNormal
+		}
+		f.SetPriority(filePriorities[name])
+	}
+	return nil
+}
+
+// wantedProgress soma o progresso dos arquivos que não foram ignorados
+func wantedProgress(t *torrent.Torrent) (completed, total int64) {
+	for _, f := range t.Files() {
+		if f.Priority() == types.PiecePriorityNone {
+			continue
+		}
+		completed += f.BytesCompleted()
+		total += f.Length()
+	}
+	return completed, total
+}
@@ -0,0 +1,112 @@
+package downloader
+
+import (
+	"maps"
+	"testing"
+
+	"github.com/anacrolix/torrent"
+	"github.com/anacrolix/torrent/bencode"
+	"github.com/anacrolix/torrent/metainfo"
+)
+
+func TestParseFilePriorities(t *testing.T) {
+	tests := []struct {
+		name  string
+		specs []string
+		want  map[int]string
+		err   bool
+	}{
+		{name: "índice", specs: []string{"3=skip"}, want: map[int]string{3: "skip"}},
+		{name: "lista e intervalo", specs: []string{"0,2-4=high"}, want: map[int]string{0: "high", 2: "high", 3: "high", 4: "high"}},
+		{name: "espaços e maiúsculas", specs: []string{" 1 , 3 - 4 = Skip "}, want: map[int]string{1: "skip", 3: "skip", 4: "skip"}},
+		{name: "intervalo de um arquivo", specs: []string{"5-5=now"}, want: map[int]string{5: "now"}},
+		{name: "sobreposição na mesma especificação", specs: []string{"0-2,1-3=skip"}, want: map[int]string{0: "skip", 1: "skip", 2: "skip", 3: "skip"}},
+		{
+			name:  "a última especificação prevalece",
+			specs: []string{"0-3=skip", "2=high"},
+			want:  map[int]string{0: "skip", 1: "skip", 2: "high", 3: "skip"},
+		},
+		{name: "vazia", specs: []string{""}, err: true},
+		{name: "sem índices", specs: []string{"=skip"}, err: true},
+		{name: "sem prioridade", specs: []string{"0="}, err: true},
+		{name: "sem igual", specs: []string{"0-2"}, err: true},
+		{name: "prioridade desconhecida", specs: []string{"0=urgente"}, err: true},
+		{name: "índice negativo", specs: []string{"-1=skip"}, err: true},
+		{name: "intervalo invertido", specs: []string{"4-2=skip"}, err: true},
+		{name: "intervalo aberto", specs: []string{"2-=skip"}, err: true},
+		{name: "índice vazio na lista", specs: []string{"0,,2=skip"}, err: true},
+		{name: "índice não numérico", specs: []string{"a=skip"}, err: true},
+		{name: "intervalo grande demais", specs: []string{"0-999999999=skip"}, err: true},
+		{name: "índice grande demais", specs: []string{"99999999999999999999=skip"}, err: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := make(map[int]string)
+			var err error
+			for _, spec := range tt.specs {
+				if err = ParseFilePriorities(spec, got); err != nil {
+					break
+				}
+			}
+			if tt.err {
+				if err == nil {
+					t.Fatalf("%q aceita: %v", tt.specs, got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !maps.Equal(got, tt.want) {
+				t.Errorf("prioridades %v, esperadas %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// Uma especificação inválida não deixa parte dos índices aplicada
+func TestParseFilePrioritiesKeepsPrevious(t *testing.T) {
+	priorities := map[int]string{0: "high"}
+	if err := ParseFilePriorities("0,1,x=skip", priorities); err == nil {
+		t.Fatal("especificação inválida aceita")
+	}
+	if !maps.Equal(priorities, map[int]string{0: "high"}) {
+		t.Errorf("prioridades alteradas: %v", priorities)
+	}
+}
+
+func TestApplyPriorities(t *testing.T) {
+	cl, err := torrent.NewClient(torrent.TestingConfig(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cl.Close()
+
+	info := metainfo.Info{
+		Name:        "teste",
+		PieceLength: 16 << 10,
+		Pieces:      make([]byte, 20),
+		Files: []metainfo.FileInfo{
+			{Path: []string{"a"}, Length: 1000},
+			{Path: []string{"b"}, Length: 1000},
+		},
+	}
+	mi := &metainfo.MetaInfo{InfoBytes: bencode.MustMarshal(info)}
+	tor, err := cl.AddTorrent(mi)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := applyPriorities(tor, map[int]string{2: PrioritySkip}); err == nil {
+		t.Error("índice fora do torrent aceito")
+	}
+	if err := applyPriorities(tor, map[int]string{1: PrioritySkip}); err != nil {
+		t.Fatal(err)
+	}
+	for i, want := range []string{PriorityNormal, PrioritySkip} {
+		if got := priorityName(tor.Files()[i].Priority()); got != want {
+			t.Errorf("arquivo %d: prioridade %s, esperada %s", i, got, want)
+		}
+	}
+}
@@ -0,0 +1,65 @@
+package downloader
+
+import (
+	"time"
+
+	"github.com/alucod3/gorrent/internal/history"
+	"github.com/alucod3/gorrent/internal/hooks"
+	"github.com/anacrolix/torrent"
+	"github.com/anacrolix/torrent/metainfo"
+)
+
+// historyEntry descreve um download que terminou a partir do payload dos hooks.
+// t é nil quando o torrent nem chegou a ser adicionado; downloaded é quanto foi
+// baixado da rede desde started.
+func historyEntry(p hooks.Payload, t *torrent.Torrent, link string, started time.Time, downloaded int64) history.Entry {
+	now := time.Now()
+	e := history.Entry{
+		Name:     p.Name,
+		InfoHash: p.InfoHash,
+		Link:     link,
+		Category: p.Category,
+		Size:     p.Size,
+		Path:     p.Path,
+		Started:  started,
+		Finished: now,
+		Error:    p.Error,
+	}
+	if t != nil {
+		e.Magnet = magnetLink(t)
+	}
+	if elapsed := now.Sub(started).Seconds(); elapsed > 0 {
+		e.AverageSpeed = float64(downloaded) / elapsed
+	}
+
+	switch p.Event {
+	case hooks.EventComplete:
+		e.Result = history.ResultCompleted
+	case hooks.EventCancel:
+		e.Result = history.ResultCanceled
+	default:
+		e.Result = history.ResultFailed
+	}
+	return e
+}
+
+// magnetLink monta um magnet com os info hashes e trackers do torrent, ou
+// retorna "" se os metadados ainda não são conhecidos
+func magnetLink(t *torrent.Torrent) string {
+	info := t.Info()
+	if info == nil {
+		return ""
+	}
+
+	m := metainfo.MagnetV2{DisplayName: t.Name()}
+	if info.HasV1() {
+		m.InfoHash.Set(t.InfoHash())
+	}
+	if v2, ok := infoHashV2(t); ok {
+		m.V2InfoHash.Set(v2)
+	}
+	for _, tier := range announceList(t) {
+		m.Trackers = append(m.Trackers, tier...)
+	}
+	return m.String()
+}
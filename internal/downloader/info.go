@@ -0,0 +1,102 @@
+package downloader
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/anacrolix/torrent/metainfo"
+	infohash_v2 "github.com/anacrolix/torrent/types/infohash-v2"
+)
+
+// TorrentInfo descreve o conteúdo de um arquivo .torrent ou magnet link sem
+// adicioná-lo a um cliente
+type TorrentInfo struct {
+	Name string
+	// InfoHash é o info hash v1 e InfoHashV2 o v2; um torrent híbrido tem os dois
+	InfoHash   string
+	InfoHashV2 string
+	// Version é v1, v2 ou hybrid; magnets só trazem a versão junto com os metadados
+	Version string
+	// HasInfo indica se os campos abaixo, que vêm do info dict, são conhecidos;
+	// magnets só os trazem depois que os metadados são baixados
+	HasInfo      bool
+	Size         int64
+	Files        int
+	PieceLength  int64
+	Pieces       int
+	Private      bool
+	CreatedBy    string
+	CreationDate time.Time
+	Comment      string
+	Trackers     [][]string
+	// WebSeeds são os web seeds do url-list ou dos parâmetros ws= do magnet
+	WebSeeds []string
+}
+
+// LoadInfo lê as informações de um arquivo .torrent ou de um magnet link
+func LoadInfo(link string) (TorrentInfo, error) {
+	if strings.HasPrefix(link, "magnet:") {
+		m, err := metainfo.ParseMagnetV2Uri(link)
+		if err == nil && !m.InfoHash.Ok && !m.V2InfoHash.Ok {
+			err = errors.New("nenhum info hash btih ou btmh")
+		}
+		if err != nil {
+			return TorrentInfo{}, fmt.Errorf("magnet link inválido: %w", err)
+		}
+		ti := TorrentInfo{
+			Name:     m.DisplayName,
+			WebSeeds: m.Params["ws"],
+		}
+		if m.InfoHash.Ok {
+			ti.InfoHash = m.InfoHash.Value.HexString()
+		}
+		if m.V2InfoHash.Ok {
+			ti.InfoHashV2 = m.V2InfoHash.Value.HexString()
+		}
+		if len(m.Trackers) > 0 {
+			ti.Trackers = [][]string{m.Trackers}
+		}
+		return ti, nil
+	}
+
+	if _, err := os.Stat(link); err != nil {
+		return TorrentInfo{}, err
+	}
+	mi, err := metainfo.LoadFromFile(link)
+	if err != nil {
+		return TorrentInfo{}, fmt.Errorf("arquivo .torrent inválido: %w", err)
+	}
+	info, err := mi.UnmarshalInfo()
+	if err != nil {
+		return TorrentInfo{}, fmt.Errorf("arquivo .torrent inválido: %w", err)
+	}
+
+	ti := TorrentInfo{
+		Name:        info.BestName(),
+		Version:     infoVersion(&info),
+		HasInfo:     true,
+		Size:        info.TotalLength(),
+		Files:       len(info.UpvertedFiles()),
+		PieceLength: info.PieceLength,
+		Pieces:      info.NumPieces(),
+		Private:     info.Private != nil && *info.Private,
+		CreatedBy:   mi.CreatedBy,
+		Comment:     mi.Comment,
+		Trackers:    mi.UpvertedAnnounceList(),
+		WebSeeds:    mi.UrlList,
+	}
+	if info.HasV1() {
+		ti.InfoHash = mi.HashInfoBytes().HexString()
+	}
+	if info.HasV2() {
+		v2 := infohash_v2.HashBytes(mi.InfoBytes)
+		ti.InfoHashV2 = v2.HexString()
+	}
+	if mi.CreationDate > 0 {
+		ti.CreationDate = time.Unix(mi.CreationDate, 0)
+	}
+	return ti, nil
+}
@@ -0,0 +1,204 @@
+package downloader
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"math/rand/v2"
+	"net"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/alucod3/gorrent/internal/config"
+	"github.com/anacrolix/torrent"
+	"github.com/anacrolix/torrent/metainfo"
+)
+
+// Descoberta de peers na rede local (BEP 14)
+const (
+	lsdInterval = 5 * time.Minute
+	// Quantos infohashes cabem em um anúncio sem passar de 1400 bytes
+	lsdMaxInfoHashes = 20
+	// peerSourceLSD identifica os peers encontrados na rede local
+	peerSourceLSD torrent.PeerSource = "L"
+)
+
+// lsdGroups são os grupos multicast onde os anúncios são feitos
+var lsdGroups = []struct {
+	network string
+	address string
+}{
+	{"udp4", "239.192.152.143:6771"},
+	{"udp6", "[ff15::efc0:988f]:6771"},
+}
+
+// lsd anuncia os torrents na rede local e recebe os anúncios de outros clientes
+type lsd struct {
+	client *torrent.Client
+	cookie string
+	conns  []*lsdConn
+	done   chan struct{}
+	wg     sync.WaitGroup
+}
+
+// lsdConn é a conexão com um grupo multicast. Os anúncios saem por outro
+// socket, que ao contrário do primeiro também os entrega a clientes nesta máquina.
+type lsdConn struct {
+	conn  *net.UDPConn
+	send  *net.UDPConn
+	group *net.UDPAddr
+}
+
+// newLSD entra nos grupos multicast das famílias IP habilitadas. Redes sem
+// multicast ficam sem descoberta local, sem que isso seja um erro.
+func newLSD(client *torrent.Client, cfg *config.Config) *lsd {
+	l := &lsd{
+		client: client,
+		cookie: strconv.FormatUint(rand.Uint64(), 16),
+		done:   make(chan struct{}),
+	}
+
+	for _, g := range lsdGroups {
+		if (g.network == "udp4" && cfg.DisableIPv4) || (g.network == "udp6" && cfg.DisableIPv6) {
+			continue
+		}
+		group, err := net.ResolveUDPAddr(g.network, g.address)
+		if err != nil {
+			continue
+		}
+		conn, err := net.ListenMulticastUDP(g.network, nil, group)
+		if err != nil {
+			continue
+		}
+		send, err := net.ListenUDP(g.network, nil)
+		if err != nil {
+			conn.Close()
+			continue
+		}
+		c := &lsdConn{conn: conn, send: send, group: group}
+		l.conns = append(l.conns, c)
+		l.wg.Add(1)
+		go l.receive(c)
+	}
+
+	l.wg.Add(1)
+	go l.run()
+	return l
+}
+
+// close sai dos grupos multicast
+func (l *lsd) close() {
+	close(l.done)
+	for _, c := range l.conns {
+		c.conn.Close()
+		c.send.Close()
+	}
+	l.wg.Wait()
+}
+
+// run anuncia periodicamente todos os torrents públicos
+func (l *lsd) run() {
+	defer l.wg.Done()
+
+	ticker := time.NewTicker(lsdInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			l.announce(l.client.Torrents())
+		case <-l.done:
+			return
+		}
+	}
+}
+
+// announce anuncia os torrents na rede local, em lotes que cabem em um pacote
+func (l *lsd) announce(torrents []*torrent.Torrent) {
+	var hashes []string
+	for _, t := range torrents {
+		if !isPrivate(t) {
+			hashes = append(hashes, t.InfoHash().HexString())
+		}
+	}
+
+	for len(hashes) > 0 {
+		n := min(len(hashes), lsdMaxInfoHashes)
+		for _, c := range l.conns {
+			c.send.WriteToUDP(l.message(c.group, hashes[:n]), c.group)
+		}
+		hashes = hashes[n:]
+	}
+}
+
+// message monta um anúncio BT-SEARCH
+func (l *lsd) message(group *net.UDPAddr, hashes []string) []byte {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "BT-SEARCH * HTTP/1.1\r\n")
+	fmt.Fprintf(&b, "Host: %s\r\n", group)
+	fmt.Fprintf(&b, "Port: %d\r\n", l.client.LocalPort())
+	for _, h := range hashes {
+		fmt.Fprintf(&b, "Infohash: %s\r\n", h)
+	}
+	fmt.Fprintf(&b, "cookie: %s\r\n\r\n\r\n", l.cookie)
+	return b.Bytes()
+}
+
+// receive adiciona aos torrents os peers anunciados por outros clientes
+func (l *lsd) receive(c *lsdConn) {
+	defer l.wg.Done()
+
+	buf := make([]byte, 1500)
+	for {
+		n, src, err := c.conn.ReadFromUDP(buf)
+		if err != nil {
+			// A conexão foi fechada
+			return
+		}
+
+		port, hashes, ok := l.parse(buf[:n])
+		if !ok {
+			continue
+		}
+		peer := torrent.PeerInfo{
+			Addr:   &net.TCPAddr{IP: src.IP, Port: port},
+			Source: peerSourceLSD,
+		}
+		for _, ih := range hashes {
+			if t, ok := l.client.Torrent(ih); ok && !isPrivate(t) {
+				t.AddPeers([]torrent.PeerInfo{peer})
+			}
+		}
+	}
+}
+
+// parse lê a porta e os infohashes de um anúncio, ignorando os enviados por este cliente
+func (l *lsd) parse(msg []byte) (int, []metainfo.Hash, bool) {
+	r := textproto.NewReader(bufio.NewReader(bytes.NewReader(msg)))
+	line, err := r.ReadLine()
+	if err != nil || !strings.HasPrefix(line, "BT-SEARCH * HTTP/1.") {
+		return 0, nil, false
+	}
+	header, err := r.ReadMIMEHeader()
+	if err != nil && len(header) == 0 {
+		return 0, nil, false
+	}
+	if header.Get("Cookie") == l.cookie {
+		return 0, nil, false
+	}
+
+	port, err := strconv.Atoi(header.Get("Port"))
+	if err != nil || port <= 0 || port > 65535 {
+		return 0, nil, false
+	}
+	var hashes []metainfo.Hash
+	for _, h := range header.Values("Infohash") {
+		var ih metainfo.Hash
+		if ih.FromHexString(strings.TrimSpace(h)) == nil {
+			hashes = append(hashes, ih)
+		}
+	}
+	return port, hashes, len(hashes) > 0
+}
@@ -0,0 +1,828 @@
+package downloader
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/alucod3/gorrent/internal/config"
+	"github.com/alucod3/gorrent/internal/history"
+	"github.com/alucod3/gorrent/internal/hooks"
+	"github.com/anacrolix/torrent"
+	"github.com/anacrolix/torrent/metainfo"
+)
+
+// Estados possíveis de um torrent gerenciado
+const (
+	StateMetadata    = "metadata"
+	StateDownloading = "downloading"
+	StatePaused      = "paused"
+	StateSeeding     = "seeding"
+	StateMoving      = "moving"
+	StateCompleted   = "completed"
+	StateFailed      = "failed"
+)
+
+// ErrNotFound indica que nenhum torrent corresponde ao identificador informado
+var ErrNotFound = errors.New("torrent não encontrado")
+
+// Status descreve o estado atual de um torrent gerenciado
+type Status struct {
+	ID             string  `json:"id"`
+	Name           string  `json:"name"`
+	State          string  `json:"state"`
+	Size           int64   `json:"size"`
+	BytesCompleted int64   `json:"bytes_completed"`
+	Progress       float64 `json:"progress"`
+	Peers          int     `json:"peers"`
+	DownloadSpeed  float64 `json:"download_speed"`
+	UploadSpeed    float64 `json:"upload_speed"`
+	Uploaded       int64   `json:"uploaded"`
+	Category       string  `json:"category,omitempty"`
+	Sequential     string  `json:"sequential,omitempty"`
+	Encryption     string  `json:"encryption"`
+	Private        bool    `json:"private,omitempty"`
+	Version        string  `json:"version,omitempty"`
+	Error          string  `json:"error,omitempty"`
+
+	// InfoHashV2 e Files só são preenchidos ao consultar um torrent específico
+	InfoHashV2 string       `json:"info_hash_v2,omitempty"`
+	Files      []FileStatus `json:"files,omitempty"`
+}
+
+// Stats resume a atividade do gerenciador como um todo
+type Stats struct {
+	Torrents      int     `json:"torrents"`
+	Peers         int     `json:"peers"`
+	DownloadSpeed float64 `json:"download_speed"`
+	UploadSpeed   float64 `json:"upload_speed"`
+	// Blocklist só é preenchido quando há listas de bloqueio configuradas
+	Blocklist *BlocklistStatus `json:"blocklist,omitempty"`
+	// PortMappings só é preenchido com o mapeamento de porta ativado
+	PortMappings []PortMapping `json:"port_mappings,omitempty"`
+	Session      SessionStatus `json:"session"`
+}
+
+// FileStatus descreve o progresso de um arquivo dentro de um torrent
+type FileStatus struct {
+	Index          int     `json:"index"`
+	Priority       string  `json:"priority"`
+	Path           string  `json:"path"`
+	Size           int64   `json:"size"`
+	BytesCompleted int64   `json:"bytes_completed"`
+	Progress       float64 `json:"progress"`
+}
+
+// task guarda o estado de um torrent dentro do gerenciador
+type task struct {
+	t    *torrent.Torrent
+	link string
+	opts Options
+	// started é quando o torrent foi adicionado, para a velocidade média do histórico
+	started       time.Time
+	category      *config.Category
+	seq           *sequencer
+	paused        bool
+	completed     bool
+	moving        bool
+	moved         bool
+	seedDone      bool
+	baseUploaded  int64
+	baseRead      int64
+	err           error
+	lastRead      int64
+	lastWritten   int64
+	downloadSpeed float64
+	uploadSpeed   float64
+	// existing é o diretório da cópia já baixada que está sendo usada, se houver
+	existing string
+	// metainfo guarda o .torrent em bencode para a sessão, depois dos metadados
+	metainfo []byte
+	// restored indica que o torrent veio da sessão anterior do daemon
+	restored bool
+}
+
+// dropped indica que o torrent foi pulado por já ter sido baixado e saiu do
+// cliente; a tarefa fica só como registro para a listagem
+func (tk *task) dropped() bool {
+	return errors.Is(tk.err, ErrDuplicate)
+}
+
+// Manager mantém um cliente torrent de longa duração com vários torrents
+type Manager struct {
+	config     *config.Config
+	engine     *engine
+	client     *torrent.Client
+	hooks      *hooks.Runner
+	history    *history.Store
+	mu         sync.Mutex
+	tasks      map[string]*task
+	lastSample time.Time
+	done       chan struct{}
+	// session guarda os torrents para restaurá-los quando o daemon reiniciar;
+	// dirty indica mudanças ainda não gravadas
+	session       *session
+	sessionStatus SessionStatus
+	dirty         bool
+}
+
+// NewManager cria um gerenciador com um cliente torrent próprio; hooks pode ser nil
+func NewManager(cfg *config.Config, hooks *hooks.Runner) (*Manager, error) {
+	if err := cfg.EnsureDownloadPath(); err != nil {
+		return nil, fmt.Errorf("erro ao criar diretório de download: %w", err)
+	}
+
+	e, err := newEngine(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	m := &Manager{
+		config:     cfg,
+		engine:     e,
+		client:     e.client,
+		hooks:      hooks,
+		history:    history.New(cfg.HistoryPath()),
+		tasks:      make(map[string]*task),
+		lastSample: time.Now(),
+		done:       make(chan struct{}),
+		session:    &session{path: cfg.SessionPath(), quarantine: cfg.SessionQuarantinePath()},
+	}
+	if err := m.restoreSession(); err != nil {
+		e.Close()
+		return nil, err
+	}
+	go m.monitor()
+	if e.blocklist != nil && cfg.BlocklistRefresh.Duration > 0 {
+		go m.refreshBlocklist()
+	}
+
+	return m, nil
+}
+
+// Close encerra o monitoramento, grava a sessão e encerra o cliente torrent
+func (m *Manager) Close() {
+	close(m.done)
+
+	// Os totais enviados só são gravados junto com outras mudanças, então a
+	// sessão é gravada de novo ao sair
+	m.mu.Lock()
+	m.saveSession()
+	m.mu.Unlock()
+
+	m.engine.Close()
+}
+
+// Add adiciona um torrent e inicia o download assim que os metadados chegarem
+func (m *Manager) Add(link string, opts Options) (Status, error) {
+	if err := opts.validate(m.config); err != nil {
+		return Status{}, err
+	}
+
+	t, err := m.engine.add(link)
+	if err != nil {
+		return Status{}, err
+	}
+	if err := m.engine.trackers.add(t, opts.Trackers); err != nil {
+		m.engine.remove(t)
+		return Status{}, err
+	}
+	t.AddWebSeeds(opts.WebSeeds)
+	return m.register(t, link, opts), nil
+}
+
+// AddTorrentFile adiciona um torrent a partir do conteúdo de um arquivo .torrent
+func (m *Manager) AddTorrentFile(r io.Reader, opts Options) (Status, error) {
+	if err := opts.validate(m.config); err != nil {
+		return Status{}, err
+	}
+
+	mi, err := metainfo.Load(r)
+	if err != nil {
+		return Status{}, fmt.Errorf("arquivo .torrent inválido: %w", err)
+	}
+
+	t, err := m.engine.addMetaInfo(mi)
+	if err != nil {
+		return Status{}, err
+	}
+	if err := m.engine.trackers.add(t, opts.Trackers); err != nil {
+		m.engine.remove(t)
+		return Status{}, err
+	}
+	t.AddWebSeeds(opts.WebSeeds)
+	return m.register(t, "", opts), nil
+}
+
+// register passa a acompanhar um torrent recém-adicionado ao cliente; link é
+// a origem registrada no histórico, vazia para arquivos .torrent enviados
+func (m *Manager) register(t *torrent.Torrent, link string, opts Options) Status {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	id := t.InfoHash().HexString()
+	// Um torrent pulado já saiu do cliente, então o readicionado é outro
+	tk, ok := m.tasks[id]
+	if !ok || tk.dropped() {
+		tk = &task{t: t, link: link, opts: opts, started: time.Now()}
+		m.tasks[id] = tk
+		m.watchErrors(tk)
+		m.dirty = true
+		go m.start(tk)
+	}
+
+	return m.status(id, tk)
+}
+
+// start aguarda os metadados e inicia o download, caso não esteja pausado
+func (m *Manager) start(tk *task) {
+	select {
+	case <-tk.t.GotInfo():
+	case <-tk.t.Closed():
+		return
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	// O metainfo passa a ser guardado na sessão
+	m.dirty = true
+
+	// Com os metadados é possível escolher a categoria pelas regras
+	tk.category = matchCategory(m.config, tk.t, tk.opts.Category)
+	m.engine.throttle.assign(tk.t.InfoHash(), tk.category)
+
+	// Um torrent já baixado pode ser ignorado ou semeado a partir da cópia
+	// existente; um restaurado da sessão já passou por essa escolha
+	if !tk.restored && !m.handleDuplicate(tk) {
+		return
+	}
+	// Os dados não poderiam ser movidos ao terminar, então o download nem começa
+	if !tk.restored && tk.existing == "" {
+		if path, ok := m.engine.occupied(tk.t, destinationDir(m.config, tk.category)); ok {
+			m.failLocked(tk, errOccupied(path))
+			return
+		}
+	}
+	if tk.completed && !m.uploadAllowed(tk) {
+		m.engine.throttle.disallowUpload(tk.t)
+	}
+
+	// Pausado ou não, as prioridades já ficam definidas; a pausa bloqueia a troca de dados
+	if err := applyPriorities(tk.t, tk.opts.FilePriorities); err != nil {
+		m.failLocked(tk, err)
+		return
+	}
+	seq, err := newSequencer(tk.t, tk.opts, m.config.SequentialReadahead)
+	if err != nil {
+		m.failLocked(tk, err)
+		return
+	}
+	tk.seq = seq
+}
+
+// handleDuplicate segue a ação para torrents já baixados; sem como perguntar,
+// o daemon semeia a cópia existente. Retorna false se o torrent não deve ser
+// baixado; deve ser chamado com o mutex travado.
+func (m *Manager) handleDuplicate(tk *task) bool {
+	action, err := duplicateAction(m.config, tk.opts.Duplicate)
+	if err != nil {
+		m.failLocked(tk, err)
+		return false
+	}
+	if action == DuplicateDownload {
+		return true
+	}
+	dup, found := findDuplicate(m.config, m.history, tk.t, tk.category)
+	if !found {
+		return true
+	}
+
+	if action == DuplicateSkip {
+		// O torrent sai do cliente para não anunciar nem trocar dados, e a
+		// tarefa fica falha para mostrar o motivo, mas sem hooks nem
+		// histórico, já que não chegou a ser baixado
+		tk.err = fmt.Errorf("%w: %s", ErrDuplicate, dup)
+		m.engine.remove(tk.t)
+		return false
+	}
+	// Sem cópia para semear, o torrent é baixado de novo
+	if dup.path == "" {
+		return true
+	}
+
+	existing, err := m.engine.useExisting(tk.t, dup.dir())
+	if err != nil {
+		m.failLocked(tk, err)
+		return false
+	}
+	if existing != tk.t {
+		tk.t = existing
+		tk.existing = dup.dir()
+		// Os dados já estão no lugar e não são movidos ao terminar
+		tk.moved = true
+		m.watchErrors(tk)
+		if tk.paused {
+			existing.DisallowDataDownload()
+			m.engine.throttle.disallowUpload(existing)
+		}
+	}
+	return true
+}
+
+// List retorna o estado de todos os torrents
+func (m *Manager) List() []Status {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	list := make([]Status, 0, len(m.tasks))
+	for id, tk := range m.tasks {
+		list = append(list, m.status(id, tk))
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Name < list[j].Name
+	})
+	return list
+}
+
+// Stats retorna os totais de todos os torrents e das listas de bloqueio
+func (m *Manager) Stats() Stats {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	st := Stats{
+		Torrents:     len(m.tasks),
+		Blocklist:    m.engine.blocklist.status(),
+		PortMappings: m.engine.portfwd.status(),
+		Session:      m.sessionStatus,
+	}
+	for _, tk := range m.tasks {
+		st.Peers += tk.t.Stats().ActivePeers
+		st.DownloadSpeed += tk.downloadSpeed
+		st.UploadSpeed += tk.uploadSpeed
+	}
+	return st
+}
+
+// Get retorna o estado de um torrent
+func (m *Manager) Get(id string) (Status, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	id, tk, err := m.lookup(id)
+	if err != nil {
+		return Status{}, err
+	}
+
+	st := m.status(id, tk)
+	if v2, ok := infoHashV2(tk.t); ok {
+		st.InfoHashV2 = v2.HexString()
+	}
+	if tk.t.Info() != nil {
+		for i, f := range tk.t.Files() {
+			fs := FileStatus{
+				Index:          i,
+				Priority:       priorityName(f.Priority()),
+				Path:           f.DisplayPath(),
+				Size:           f.Length(),
+				BytesCompleted: f.BytesCompleted(),
+			}
+			if fs.Size > 0 {
+				fs.Progress = float64(fs.BytesCompleted) / float64(fs.Size) * 100
+			}
+			st.Files = append(st.Files, fs)
+		}
+	}
+	return st, nil
+}
+
+// Peers retorna os peers conectados a um torrent
+func (m *Manager) Peers(id string) ([]PeerStatus, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	_, tk, err := m.lookup(id)
+	if err != nil {
+		return nil, err
+	}
+	return m.engine.peers.list(tk.t), nil
+}
+
+// Trackers retorna o estado dos trackers de um torrent
+func (m *Manager) Trackers(id string) ([]TrackerStatus, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	_, tk, err := m.lookup(id)
+	if err != nil {
+		return nil, err
+	}
+	return m.engine.trackers.list(tk.t.InfoHash()), nil
+}
+
+// AddTrackers acrescenta trackers a um torrent que já está no gerenciador
+func (m *Manager) AddTrackers(id string, urls []string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	_, tk, err := m.lookup(id)
+	if err != nil {
+		return err
+	}
+	if tk.dropped() {
+		return tk.err
+	}
+	if err := m.engine.trackers.add(tk.t, urls); err != nil {
+		return err
+	}
+	tk.opts.Trackers = append(slices.Clip(tk.opts.Trackers), urls...)
+	tk.metainfo = nil
+	m.dirty = true
+	return nil
+}
+
+// Pause interrompe a troca de dados de um torrent
+func (m *Manager) Pause(id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	_, tk, err := m.lookup(id)
+	if err != nil {
+		return err
+	}
+	if tk.dropped() {
+		return tk.err
+	}
+
+	tk.paused = true
+	tk.t.DisallowDataDownload()
+	m.engine.throttle.disallowUpload(tk.t)
+	m.dirty = true
+	return nil
+}
+
+// Resume retoma a troca de dados de um torrent pausado
+func (m *Manager) Resume(id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	_, tk, err := m.lookup(id)
+	if err != nil {
+		return err
+	}
+	if tk.dropped() {
+		return tk.err
+	}
+
+	tk.paused = false
+	tk.t.AllowDataDownload()
+	if m.uploadAllowed(tk) {
+		m.engine.throttle.allowUpload(tk.t)
+	}
+	m.dirty = true
+	return nil
+}
+
+// SetFilePriority altera a prioridade de um arquivo, inclusive durante o download
+func (m *Manager) SetFilePriority(id string, index int, priority string) error {
+	if err := validatePriority(priority); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	_, tk, err := m.lookup(id)
+	if err != nil {
+		return err
+	}
+	if tk.dropped() {
+		return tk.err
+	}
+
+	if tk.t.Info() != nil {
+		files := tk.t.Files()
+		if index < 0 || index >= len(files) {
+			return fmt.Errorf("o torrent tem %d arquivos, índice %d não existe", len(files), index)
+		}
+		f := files[index]
+		f.SetPriority(filePriorities[priority])
+
+		// Um arquivo ignorado que volta a ser desejado reabre o download
+		if tk.completed && !tk.moving && priority != PrioritySkip && f.BytesCompleted() < f.Length() {
+			tk.completed = false
+			if !tk.paused {
+				m.engine.throttle.allowUpload(tk.t)
+			}
+		}
+	}
+
+	// Guardada nas opções para valer quando os metadados chegarem ou o torrent for readicionado
+	priorities := make(map[int]string, len(tk.opts.FilePriorities)+1)
+	for i, p := range tk.opts.FilePriorities {
+		priorities[i] = p
+	}
+	priorities[index] = priority
+	tk.opts.FilePriorities = priorities
+	m.dirty = true
+	return nil
+}
+
+// Remove retira um torrent do cliente, mantendo os dados já baixados
+func (m *Manager) Remove(id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	id, tk, err := m.lookup(id)
+	if err != nil {
+		return err
+	}
+
+	delete(m.tasks, id)
+	m.dirty = true
+	m.engine.throttle.assign(tk.t.InfoHash(), nil)
+	if !tk.completed && tk.err == nil {
+		m.runHooks(tk, hooks.EventCancel)
+	}
+	if !tk.dropped() {
+		m.engine.remove(tk.t)
+	}
+	return nil
+}
+
+// watchErrors faz erros de escrita no disco marcarem o torrent como falho
+func (m *Manager) watchErrors(tk *task) {
+	t := tk.t
+	t.SetOnWriteChunkError(func(err error) {
+		m.fail(tk, err)
+	})
+}
+
+// finish move um torrent concluído para o diretório de concluídos e dispara os hooks
+func (m *Manager) finish(id string, tk *task, t *torrent.Torrent, alreadyMoved bool) {
+	seed := shouldSeed(m.config, tk.category)
+
+	// A movimentação pode demorar ao copiar entre sistemas de arquivos,
+	// então é feita sem travar o gerenciador. Um torrent que volta a ser
+	// concluído após mudar prioridades já está no destino.
+	moved := t
+	var err error
+	if !alreadyMoved {
+		moved, err = m.engine.moveCompleted(t, destinationDir(m.config, tk.category), seed)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	tk.moving = false
+	m.dirty = true
+	if err != nil {
+		m.failLocked(tk, err)
+		return
+	}
+	tk.moved = true
+
+	if moved != nil && moved != tk.t {
+		if _, ok := m.tasks[id]; !ok {
+			// Removido enquanto os dados eram movidos
+			m.engine.remove(moved)
+			return
+		}
+		// As estatísticas recomeçam no torrent adicionado novamente
+		tk.baseUploaded += tk.lastWritten
+		tk.baseRead += tk.lastRead
+		tk.lastRead = 0
+		tk.lastWritten = 0
+		tk.t = moved
+		tk.seq = nil
+		m.watchErrors(tk)
+		if err := applyPriorities(moved, tk.opts.FilePriorities); err != nil {
+			m.failLocked(tk, err)
+			return
+		}
+		if tk.paused {
+			moved.DisallowDataDownload()
+			m.engine.throttle.disallowUpload(moved)
+		}
+	}
+	if !seed {
+		m.engine.throttle.disallowUpload(tk.t)
+	}
+	m.runHooks(tk, hooks.EventComplete)
+}
+
+// fail interrompe um torrent após um erro de escrita no disco
+func (m *Manager) fail(tk *task, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.failLocked(tk, err)
+}
+
+// failLocked marca o torrent como falho; deve ser chamado com o mutex travado
+func (m *Manager) failLocked(tk *task, err error) {
+	if tk.err != nil {
+		return
+	}
+	tk.err = err
+	tk.t.DisallowDataDownload()
+	m.runHooks(tk, hooks.EventFailure)
+}
+
+// runHooks dispara em segundo plano os hooks de um evento do torrent e o
+// registra no histórico
+func (m *Manager) runHooks(tk *task, event hooks.Event) {
+	dir := m.config.IncompleteDir()
+	switch {
+	case tk.existing != "":
+		dir = tk.existing
+	case event == hooks.EventComplete:
+		dir = destinationDir(m.config, tk.category)
+	}
+
+	p := payloadFor(tk.t, dir)
+	p.Event = event
+	p.Category = categoryName(tk.category)
+	if tk.err != nil {
+		p.Error = tk.err.Error()
+	}
+	go m.hooks.Run(p)
+
+	stats := tk.t.Stats()
+	read := tk.baseRead + stats.BytesReadUsefulData.Int64()
+	go m.history.Add(historyEntry(p, tk.t, tk.link, tk.started, read))
+}
+
+// lookup encontra um torrent pelo info hash completo ou por um prefixo único
+func (m *Manager) lookup(id string) (string, *task, error) {
+	id = strings.ToLower(id)
+	if tk, ok := m.tasks[id]; ok {
+		return id, tk, nil
+	}
+
+	var (
+		foundID string
+		found   *task
+	)
+	for candidate, tk := range m.tasks {
+		if id != "" && strings.HasPrefix(candidate, id) {
+			if found != nil {
+				return "", nil, fmt.Errorf("identificador ambíguo: %s", id)
+			}
+			foundID, found = candidate, tk
+		}
+	}
+	if found == nil {
+		return "", nil, ErrNotFound
+	}
+	return foundID, found, nil
+}
+
+// status monta o estado de um torrent; deve ser chamado com o mutex travado
+func (m *Manager) status(id string, tk *task) Status {
+	t := tk.t
+	stats := t.Stats()
+	st := Status{
+		ID:            id,
+		Name:          t.Name(),
+		Peers:         stats.ActivePeers,
+		DownloadSpeed: tk.downloadSpeed,
+		UploadSpeed:   tk.uploadSpeed,
+		Uploaded:      tk.baseUploaded + stats.BytesWrittenData.Int64(),
+		Category:      categoryName(tk.category),
+		Encryption:    m.config.Encryption,
+		Private:       isPrivate(t),
+	}
+	if tk.seq != nil {
+		st.Sequential = tk.seq.path()
+	}
+
+	switch {
+	case tk.err != nil:
+		st.State = StateFailed
+		st.Error = tk.err.Error()
+	case tk.moving:
+		st.State = StateMoving
+	case tk.paused:
+		st.State = StatePaused
+	case t.Info() == nil:
+		st.State = StateMetadata
+	case tk.completed && !tk.seedDone && shouldSeed(m.config, tk.category):
+		st.State = StateSeeding
+	case tk.completed:
+		st.State = StateCompleted
+	default:
+		st.State = StateDownloading
+	}
+
+	// Tamanho e progresso consideram apenas os arquivos que não foram ignorados
+	if info := t.Info(); info != nil {
+		st.Version = infoVersion(info)
+		st.BytesCompleted, st.Size = wantedProgress(t)
+		if st.Size > 0 {
+			st.Progress = float64(st.BytesCompleted) / float64(st.Size) * 100
+		}
+	}
+
+	return st
+}
+
+// monitor atualiza periodicamente as velocidades e detecta downloads concluídos
+func (m *Manager) monitor() {
+	ticker := time.NewTicker(m.config.ProgressCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			m.sample()
+		case <-m.done:
+			return
+		}
+	}
+}
+
+// refreshBlocklist recarrega as listas de bloqueio no intervalo configurado.
+// Uma falha fica registrada nas estatísticas e as faixas anteriores continuam valendo.
+func (m *Manager) refreshBlocklist() {
+	ticker := time.NewTicker(m.config.BlocklistRefresh.Duration)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			m.engine.blocklist.reload()
+		case <-m.done:
+			return
+		}
+	}
+}
+
+// sample calcula as velocidades desde a última amostra
+func (m *Manager) sample() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	elapsed := now.Sub(m.lastSample).Seconds()
+	m.lastSample = now
+
+	for id, tk := range m.tasks {
+		stats := tk.t.Stats()
+		read := stats.BytesReadUsefulData.Int64()
+		written := stats.BytesWrittenData.Int64()
+
+		if elapsed > 0 {
+			tk.downloadSpeed = float64(read-tk.lastRead) / elapsed
+			tk.uploadSpeed = float64(written-tk.lastWritten) / elapsed
+		}
+		tk.lastRead = read
+		tk.lastWritten = written
+
+		if tk.t.Info() != nil && tk.err == nil && !tk.moving {
+			if !tk.completed {
+				tk.seq.update()
+			}
+			completed, total := wantedProgress(tk.t)
+			if !tk.completed && completed == total {
+				tk.completed = true
+				tk.moving = true
+				// O cliente semeia se alguma categoria semeia, então o envio
+				// dos que não semeiam é bloqueado já aqui, e não só depois de
+				// mover os dados
+				if !m.uploadAllowed(tk) {
+					m.engine.throttle.disallowUpload(tk.t)
+				}
+				go m.finish(id, tk, tk.t, tk.moved)
+			}
+		}
+
+		m.checkSeedRatio(tk, tk.baseUploaded+written)
+	}
+
+	if m.dirty {
+		m.saveSession()
+	}
+}
+
+// uploadAllowed indica se o torrent pode enviar dados segundo a política de semeadura
+func (m *Manager) uploadAllowed(tk *task) bool {
+	return !tk.completed || (shouldSeed(m.config, tk.category) && !tk.seedDone)
+}
+
+// checkSeedRatio para de semear quando a categoria atinge a proporção configurada
+func (m *Manager) checkSeedRatio(tk *task, uploaded int64) {
+	c := tk.category
+	if c == nil || c.SeedRatio <= 0 || !tk.completed || tk.moving || tk.seedDone {
+		return
+	}
+	if tk.t.Info() != nil && float64(uploaded) >= c.SeedRatio*float64(tk.t.Length()) {
+		tk.seedDone = true
+		m.engine.throttle.disallowUpload(tk.t)
+		m.dirty = true
+	}
+}
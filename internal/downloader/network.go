@@ -0,0 +1,165 @@
+package downloader
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"syscall"
+
+	"github.com/alucod3/gorrent/internal/config"
+	"github.com/anacrolix/torrent"
+	"github.com/anacrolix/torrent/mse"
+)
+
+// Políticas de criptografia das conexões com peers (MSE/PE)
+const (
+	EncryptionPrefer  = "prefer"
+	EncryptionRequire = "require"
+	EncryptionDisable = "disable"
+)
+
+// configureNetwork aplica ao cliente as configurações de rede da aplicação
+func configureNetwork(clientConfig *torrent.ClientConfig, cfg *config.Config) error {
+	if err := cfg.ValidateNetwork(); err != nil {
+		return err
+	}
+
+	clientConfig.DisableIPv4 = cfg.DisableIPv4
+	clientConfig.DisableIPv6 = cfg.DisableIPv6
+	clientConfig.DisableTCP = cfg.DisableTCP
+	clientConfig.DisableUTP = cfg.DisableUTP
+	clientConfig.EstablishedConnsPerTorrent = cfg.MaxConnectionsPerTorrent
+	if err := configureEncryption(clientConfig, cfg.Encryption); err != nil {
+		return err
+	}
+
+	if cfg.BindAddress == "" {
+		return nil
+	}
+	ip4, ip6, err := bindAddresses(cfg.BindAddress)
+	if err != nil {
+		return err
+	}
+	// Uma família sem endereço no local de escuta não tem como ser usada
+	if ip4 == "" {
+		clientConfig.DisableIPv4 = true
+	}
+	if ip6 == "" {
+		clientConfig.DisableIPv6 = true
+	}
+	if clientConfig.DisableIPv4 && clientConfig.DisableIPv6 {
+		return fmt.Errorf("%s não tem endereço de uma família IP habilitada", cfg.BindAddress)
+	}
+	clientConfig.ListenHost = func(network string) string {
+		if strings.HasSuffix(network, "6") {
+			return ip6
+		}
+		return ip4
+	}
+	return nil
+}
+
+// configureEncryption aplica a política de criptografia das conexões com peers
+func configureEncryption(clientConfig *torrent.ClientConfig, policy string) error {
+	switch policy {
+	case EncryptionPrefer:
+		// Peers sem criptografia continuam aceitos, mas quem a oferece a usa
+		clientConfig.HeaderObfuscationPolicy = torrent.HeaderObfuscationPolicy{Preferred: true}
+		clientConfig.CryptoSelector = func(provided mse.CryptoMethod) mse.CryptoMethod {
+			if provided&mse.CryptoMethodRC4 != 0 {
+				return mse.CryptoMethodRC4
+			}
+			return mse.CryptoMethodPlaintext
+		}
+	case EncryptionRequire:
+		// Só o cabeçalho ofuscado não basta: os dados também são cifrados
+		clientConfig.HeaderObfuscationPolicy = torrent.HeaderObfuscationPolicy{Preferred: true, RequirePreferred: true}
+		clientConfig.CryptoProvides = mse.CryptoMethodRC4
+		clientConfig.CryptoSelector = func(provided mse.CryptoMethod) mse.CryptoMethod {
+			return provided & mse.CryptoMethodRC4
+		}
+	case EncryptionDisable:
+		clientConfig.HeaderObfuscationPolicy = torrent.HeaderObfuscationPolicy{RequirePreferred: true}
+		clientConfig.CryptoProvides = mse.CryptoMethodPlaintext
+	default:
+		return fmt.Errorf("criptografia desconhecida %q: use prefer, require ou disable", policy)
+	}
+	return nil
+}
+
+// bindAddresses retorna os endereços IPv4 e IPv6 de um IP ou interface de rede
+func bindAddresses(bind string) (ip4, ip6 string, err error) {
+	if ip := net.ParseIP(bind); ip != nil {
+		if ip.To4() != nil {
+			return ip.String(), "", nil
+		}
+		return "", ip.String(), nil
+	}
+
+	iface, err := net.InterfaceByName(bind)
+	if err != nil {
+		return "", "", fmt.Errorf("endereço de escuta inválido %q: não é um IP nem uma interface de rede", bind)
+	}
+	addrs, err := iface.Addrs()
+	if err != nil {
+		return "", "", fmt.Errorf("erro ao ler os endereços de %s: %w", bind, err)
+	}
+	for _, addr := range addrs {
+		ipNet, ok := addr.(*net.IPNet)
+		if !ok {
+			continue
+		}
+		switch {
+		case ipNet.IP.To4() != nil:
+			if ip4 == "" {
+				ip4 = ipNet.IP.String()
+			}
+		case !ipNet.IP.IsLinkLocalUnicast():
+			// Endereços link-local exigem a zona, que o cliente não repassa
+			if ip6 == "" {
+				ip6 = ipNet.IP.String()
+			}
+		}
+	}
+	if ip4 == "" && ip6 == "" {
+		return "", "", fmt.Errorf("a interface %s não tem endereços IP", bind)
+	}
+	return ip4, ip6, nil
+}
+
+// newClient cria o cliente na primeira porta livre do intervalo configurado
+func newClient(clientConfig *torrent.ClientConfig, ports config.PortRange) (*torrent.Client, error) {
+	var err error
+	for port := ports.First; port <= ports.Last; port++ {
+		clientConfig.ListenPort = port
+		var client *torrent.Client
+		client, err = torrent.NewClient(clientConfig)
+		if err == nil {
+			return client, nil
+		}
+		if !errors.Is(err, syscall.EADDRINUSE) {
+			return nil, err
+		}
+	}
+	if ports.First != ports.Last {
+		return nil, fmt.Errorf("nenhuma porta livre entre %s: %w", ports, err)
+	}
+	return nil, err
+}
+
+// limitConns divide o limite global de conexões entre os torrents do cliente
+func (e *engine) limitConns() {
+	if e.config.MaxConnections == 0 {
+		return
+	}
+
+	torrents := e.client.Torrents()
+	if len(torrents) == 0 {
+		return
+	}
+	limit := min(e.config.MaxConnectionsPerTorrent, max(1, e.config.MaxConnections/len(torrents)))
+	for _, t := range torrents {
+		t.SetMaxEstablishedConns(limit)
+	}
+}
@@ -0,0 +1,277 @@
+package downloader
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/anacrolix/torrent"
+	pp "github.com/anacrolix/torrent/peer_protocol"
+)
+
+// Tipos de conexão com peers
+const (
+	ConnectionTCP    = "TCP"
+	ConnectionUTP    = "uTP"
+	ConnectionWebRTC = "WebRTC"
+)
+
+// PeerStatus descreve uma conexão com um peer
+type PeerStatus struct {
+	Address    string `json:"address"`
+	Client     string `json:"client,omitempty"`
+	Connection string `json:"connection"`
+	// Encrypted é nil quando não se sabe se a conexão usa criptografia MSE
+	Encrypted *bool `json:"encrypted"`
+	// Choked indica que o peer não está nos enviando dados
+	Choked bool `json:"choked"`
+	// Interested indica que o peer quer dados nossos
+	Interested   bool    `json:"interested"`
+	Seed         bool    `json:"seed"`
+	DownloadRate float64 `json:"download_rate"`
+	// EstimatedUploadRate é uma estimativa: o cliente só informa o total
+	// enviado pelo torrent, que é dividido entre os peers por sampleUploads
+	EstimatedUploadRate float64 `json:"estimated_upload_rate"`
+	// Progress é a porcentagem das peças que o peer tem
+	Progress float64 `json:"progress"`
+}
+
+// peerState é o que se sabe de um peer a partir das mensagens recebidas
+type peerState struct {
+	choked        bool
+	interested    bool
+	requested     int64
+	lastRequested int64
+	// pending são os bytes pedidos pelo peer que ainda não foram atribuídos
+	// ao que o torrent enviou
+	pending    int64
+	uploadRate float64
+}
+
+// uploadSample é o total enviado pelo torrent na última amostra das taxas
+type uploadSample struct {
+	written int64
+	time    time.Time
+}
+
+// peerTracker acompanha as mensagens dos peers, já que o cliente não expõe o
+// estado de choke e interesse nem a taxa de envio por conexão
+type peerTracker struct {
+	mu      sync.Mutex
+	policy  string
+	peers   map[*torrent.PeerConn]*peerState
+	samples map[*torrent.Torrent]uploadSample
+}
+
+// newPeerTracker cria o acompanhamento; policy é a política de criptografia
+// das conexões
+func newPeerTracker(policy string) *peerTracker {
+	return &peerTracker{
+		policy:  policy,
+		peers:   make(map[*torrent.PeerConn]*peerState),
+		samples: make(map[*torrent.Torrent]uploadSample),
+	}
+}
+
+// install registra o acompanhamento nos callbacks do cliente, sem descartar
+// os que já estavam registrados
+func (pt *peerTracker) install(cb *torrent.Callbacks) {
+	readMessage := cb.ReadMessage
+	cb.ReadMessage = func(pc *torrent.PeerConn, msg *pp.Message) {
+		pt.readMessage(pc, msg)
+		if readMessage != nil {
+			readMessage(pc, msg)
+		}
+	}
+	closed := cb.PeerConnClosed
+	cb.PeerConnClosed = func(pc *torrent.PeerConn) {
+		pt.closed(pc)
+		if closed != nil {
+			closed(pc)
+		}
+	}
+}
+
+// state retorna o estado de um peer; deve ser chamado com o mutex travado
+func (pt *peerTracker) state(pc *torrent.PeerConn) *peerState {
+	ps, ok := pt.peers[pc]
+	if !ok {
+		// Toda conexão começa com os dois lados em choke
+		ps = &peerState{choked: true}
+		pt.peers[pc] = ps
+	}
+	return ps
+}
+
+func (pt *peerTracker) readMessage(pc *torrent.PeerConn, msg *pp.Message) {
+	pt.mu.Lock()
+	defer pt.mu.Unlock()
+
+	ps := pt.state(pc)
+	switch msg.Type {
+	case pp.Choke:
+		ps.choked = true
+	case pp.Unchoke:
+		ps.choked = false
+	case pp.Interested:
+		ps.interested = true
+	case pp.NotInterested:
+		ps.interested = false
+	case pp.Request:
+		ps.requested += int64(msg.Length)
+	case pp.Cancel:
+		ps.requested -= int64(msg.Length)
+	}
+}
+
+func (pt *peerTracker) closed(pc *torrent.PeerConn) {
+	pt.mu.Lock()
+	defer pt.mu.Unlock()
+	delete(pt.peers, pc)
+}
+
+// list descreve os peers conectados a um torrent, do mais rápido ao mais lento
+func (pt *peerTracker) list(t *torrent.Torrent) []PeerStatus {
+	pt.mu.Lock()
+	defer pt.mu.Unlock()
+
+	var numPieces uint64
+	if t.Info() != nil {
+		numPieces = uint64(t.NumPieces())
+	}
+
+	conns := t.PeerConns()
+	pt.sampleUploads(t, conns)
+	encryption := pt.encryption(t)
+
+	list := make([]PeerStatus, 0)
+	for _, pc := range conns {
+		ps := pt.state(pc)
+		st := PeerStatus{
+			Address:             pc.RemoteAddr.String(),
+			Client:              peerClient(pc),
+			Connection:          connectionType(pc.Network),
+			Encrypted:           encryption(pc),
+			Choked:              ps.choked,
+			Interested:          ps.interested,
+			DownloadRate:        pc.DownloadRate(),
+			EstimatedUploadRate: ps.uploadRate,
+		}
+		if numPieces > 0 {
+			have := pc.PeerPieces().GetCardinality()
+			st.Seed = have >= numPieces
+			st.Progress = float64(min(have, numPieces)) / float64(numPieces) * 100
+		}
+		list = append(list, st)
+	}
+
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].DownloadRate+list[i].EstimatedUploadRate > list[j].DownloadRate+list[j].EstimatedUploadRate
+	})
+	return list
+}
+
+// sampleUploads atualiza a taxa de envio de cada peer. O cliente só informa o
+// total enviado pelo torrent, então esse total é dividido entre os peers na
+// proporção dos pedidos de cada um ainda não atendidos; pedidos recusados ou
+// ignorados não contam se nada foi enviado. Deve ser chamado com o mutex
+// travado.
+func (pt *peerTracker) sampleUploads(t *torrent.Torrent, conns []*torrent.PeerConn) {
+	now := time.Now()
+	stats := t.Stats()
+	written := stats.BytesWrittenData.Int64()
+
+	last, ok := pt.samples[t]
+	elapsed := now.Sub(last.time).Seconds()
+	if ok && elapsed < 1 {
+		return
+	}
+	pt.samples[t] = uploadSample{written: written, time: now}
+
+	var pending int64
+	for _, pc := range conns {
+		ps := pt.state(pc)
+		ps.pending = max(ps.pending+ps.requested-ps.lastRequested, 0)
+		ps.lastRequested = ps.requested
+		pending += ps.pending
+	}
+	sent := written - last.written
+	for _, pc := range conns {
+		ps := pt.state(pc)
+		ps.uploadRate = 0
+		if !ok || pending == 0 || sent <= 0 {
+			continue
+		}
+		share := min(int64(float64(sent)*float64(ps.pending)/float64(pending)), ps.pending)
+		ps.pending -= share
+		ps.uploadRate = float64(share) / elapsed
+	}
+}
+
+// forget descarta a amostra de envio de um torrent retirado do cliente
+func (pt *peerTracker) forget(t *torrent.Torrent) {
+	pt.mu.Lock()
+	defer pt.mu.Unlock()
+	delete(pt.samples, t)
+}
+
+// peerClient retorna o nome do programa do peer, ou o código no início do peer ID
+func peerClient(pc *torrent.PeerConn) string {
+	if name, ok := pc.PeerClientName.Load().(string); ok && name != "" {
+		return name
+	}
+	if id := pc.PeerID; id[0] == '-' && id[7] == '-' {
+		return string(id[1:7])
+	}
+	return ""
+}
+
+// connectionType traduz a rede da conexão para o tipo exibido
+func connectionType(network string) string {
+	switch {
+	case strings.HasPrefix(network, "udp"), strings.HasPrefix(network, "utp"):
+		return ConnectionUTP
+	case strings.HasPrefix(network, "webrtc"):
+		return ConnectionWebRTC
+	default:
+		return ConnectionTCP
+	}
+}
+
+// swarmKey identifica uma conexão na lista de KnownSwarm
+type swarmKey struct {
+	addr string
+	id   [20]byte
+}
+
+// encryption retorna uma função que indica se cada conexão de t usa
+// criptografia MSE. As políticas require e disable já decidem isso; com
+// prefer, depende do peer, e o cliente só expõe o resultado do handshake em
+// SupportsEncryption das conexões listadas por KnownSwarm. Uma conexão que não
+// aparece lá, por ter fechado entre as duas consultas, fica como desconhecida.
+func (pt *peerTracker) encryption(t *torrent.Torrent) func(*torrent.PeerConn) *bool {
+	yes, no := true, false
+	switch pt.policy {
+	case EncryptionRequire:
+		return func(*torrent.PeerConn) *bool { return &yes }
+	case EncryptionDisable:
+		return func(*torrent.PeerConn) *bool { return &no }
+	}
+
+	// As conexões ativas vêm depois dos peers ainda não conectados, então
+	// prevalecem quando o endereço se repete
+	swarm := make(map[swarmKey]bool)
+	for _, p := range t.KnownSwarm() {
+		if p.Addr != nil {
+			swarm[swarmKey{p.Addr.String(), p.Id}] = p.SupportsEncryption
+		}
+	}
+	return func(pc *torrent.PeerConn) *bool {
+		encrypted, ok := swarm[swarmKey{pc.RemoteAddr.String(), pc.PeerID}]
+		if !ok {
+			return nil
+		}
+		return &encrypted
+	}
+}
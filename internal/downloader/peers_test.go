@@ -0,0 +1,79 @@
+package downloader
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/anacrolix/torrent"
+	"github.com/anacrolix/torrent/metainfo"
+)
+
+// encryptionClient cria um cliente de teste com a política de criptografia informada
+func encryptionClient(t *testing.T, policy string) *torrent.Client {
+	t.Helper()
+	cfg := torrent.TestingConfig(t)
+	if err := configureEncryption(cfg, policy); err != nil {
+		t.Fatal(err)
+	}
+	cl, err := torrent.NewClient(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { cl.Close() })
+	return cl
+}
+
+func TestPeerEncryption(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy string
+		peer   string
+		want   bool
+	}{
+		{name: "os dois preferem", policy: EncryptionPrefer, peer: EncryptionPrefer, want: true},
+		{name: "peer exige", policy: EncryptionPrefer, peer: EncryptionRequire, want: true},
+		{name: "peer recusa", policy: EncryptionPrefer, peer: EncryptionDisable, want: false},
+		{name: "exigida", policy: EncryptionRequire, peer: EncryptionPrefer, want: true},
+		{name: "desligada", policy: EncryptionDisable, peer: EncryptionPrefer, want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			local, remote := encryptionClient(t, tt.policy), encryptionClient(t, tt.peer)
+			ih := metainfo.Hash{3}
+			lt, _ := local.AddTorrentInfoHash(ih)
+			rt, _ := remote.AddTorrentInfoHash(ih)
+			rt.AddClientPeer(local)
+
+			deadline := time.Now().Add(10 * time.Second)
+			for len(lt.PeerConns()) == 0 {
+				if time.Now().After(deadline) {
+					t.Fatal("os clientes não se conectaram")
+				}
+				time.Sleep(10 * time.Millisecond)
+			}
+
+			// O outro cliente pode se conectar por TCP e por uTP
+			for _, p := range newPeerTracker(tt.policy).list(lt) {
+				if p.Encrypted == nil || *p.Encrypted != tt.want {
+					t.Errorf("%s %s: criptografada %v, esperado %v", p.Connection, p.Address, p.Encrypted, tt.want)
+				}
+			}
+		})
+	}
+}
+
+// Uma conexão que não aparece em KnownSwarm fica como desconhecida, em vez de
+// ser dada como não criptografada
+func TestPeerEncryptionUnknown(t *testing.T) {
+	cl := encryptionClient(t, EncryptionPrefer)
+	tor, _ := cl.AddTorrentInfoHash(metainfo.Hash{4})
+
+	encryption := newPeerTracker(EncryptionPrefer).encryption(tor)
+	pc := &torrent.PeerConn{}
+	pc.RemoteAddr = &net.TCPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 6881}
+	if got := encryption(pc); got != nil {
+		t.Errorf("conexão desconhecida dada como criptografada: %v", *got)
+	}
+}
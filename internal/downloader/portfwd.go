@@ -0,0 +1,354 @@
+package downloader
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/anacrolix/log"
+	"github.com/anacrolix/upnp"
+)
+
+const (
+	// portMappingLease é a validade pedida ao roteador; os mapeamentos são
+	// renovados na metade do prazo, e um processo encerrado à força não deixa
+	// a porta aberta para sempre
+	portMappingLease = time.Hour
+	// upnpDiscoverTimeout é quanto tempo se espera pelas respostas dos roteadores
+	upnpDiscoverTimeout = 2 * time.Second
+	// natpmpPort é a porta do serviço NAT-PMP no gateway (RFC 6886)
+	natpmpPort = 5351
+	// portMappingWait é quanto a interface espera pelo resultado dos mapeamentos
+	portMappingWait = 5 * time.Second
+	// natpmpTries é quantas vezes um pedido é enviado, dobrando a espera a cada vez
+	natpmpTries = 4
+)
+
+// Métodos de mapeamento de porta
+const (
+	PortMappingUPnP   = "UPnP"
+	PortMappingNATPMP = "NAT-PMP"
+)
+
+// PortMapping descreve o resultado de um pedido de mapeamento da porta de
+// escuta no roteador. Sem Protocol, o erro impediu qualquer mapeamento pelo método.
+type PortMapping struct {
+	Method       string `json:"method"`
+	Protocol     string `json:"protocol,omitempty"`
+	Gateway      string `json:"gateway,omitempty"`
+	InternalPort int    `json:"internal_port"`
+	ExternalIP   string `json:"external_ip,omitempty"`
+	ExternalPort int    `json:"external_port,omitempty"`
+	Error        string `json:"error,omitempty"`
+}
+
+// portForwarder mapeia a porta de escuta nos roteadores encontrados por UPnP e
+// NAT-PMP enquanto o cliente estiver aberto
+type portForwarder struct {
+	port    int
+	logger  log.Logger
+	devices []upnp.Device
+	gateway net.IP
+	ready   chan struct{}
+	done    chan struct{}
+	wg      sync.WaitGroup
+
+	mu       sync.Mutex
+	mappings []PortMapping
+	upnp     []upnpMapping
+}
+
+// upnpMapping é um mapeamento feito por UPnP, guardado para ser removido ao fechar
+type upnpMapping struct {
+	device upnp.Device
+	proto  upnp.Protocol
+	port   int
+}
+
+// newPortForwarder começa a mapear port em segundo plano
+func newPortForwarder(port int, logger log.Logger) *portForwarder {
+	f := &portForwarder{
+		port:   port,
+		logger: logger,
+		ready:  make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+	f.wg.Add(1)
+	go f.run()
+	return f
+}
+
+// status retorna o resultado dos mapeamentos; f pode ser nil
+func (f *portForwarder) status() []PortMapping {
+	if f == nil {
+		return nil
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]PortMapping(nil), f.mappings...)
+}
+
+// wait espera a primeira tentativa de mapeamento terminar, por até timeout
+func (f *portForwarder) wait(timeout time.Duration) {
+	if f == nil {
+		return
+	}
+	select {
+	case <-f.ready:
+	case <-time.After(timeout):
+	}
+}
+
+// close para as renovações e remove os mapeamentos dos roteadores
+func (f *portForwarder) close() {
+	close(f.done)
+	f.wg.Wait()
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, m := range f.upnp {
+		m.device.DeletePortMapping(m.proto, m.port)
+	}
+	for _, m := range f.mappings {
+		if m.Method == PortMappingNATPMP && m.Protocol != "" && m.Error == "" {
+			natpmpMap(f.gateway, m.Protocol, f.port, 0, 0)
+		}
+	}
+}
+
+// run faz os mapeamentos e os renova até o cliente ser fechado
+func (f *portForwarder) run() {
+	defer f.wg.Done()
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		f.devices = upnp.Discover(0, upnpDiscoverTimeout, f.logger)
+	}()
+	go func() {
+		defer wg.Done()
+		f.gateway, _ = defaultGateway()
+	}()
+	wg.Wait()
+
+	f.mapAll()
+	close(f.ready)
+
+	ticker := time.NewTicker(portMappingLease / 2)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			f.mapAll()
+		case <-f.done:
+			return
+		}
+	}
+}
+
+// mapAll pede o mapeamento TCP e UDP em cada roteador e guarda os resultados
+func (f *portForwarder) mapAll() {
+	var mappings []PortMapping
+	var mapped []upnpMapping
+	if len(f.devices) == 0 {
+		mappings = append(mappings, PortMapping{
+			Method:       PortMappingUPnP,
+			InternalPort: f.port,
+			Error:        "nenhum roteador com UPnP encontrado",
+		})
+	}
+	for _, d := range f.devices {
+		var external string
+		if ip, err := d.GetExternalIPAddress(); err == nil {
+			external = ip.String()
+		}
+		for _, proto := range []upnp.Protocol{upnp.TCP, upnp.UDP} {
+			m := PortMapping{
+				Method:       PortMappingUPnP,
+				Protocol:     string(proto),
+				Gateway:      upnpGateway(d),
+				InternalPort: f.port,
+				ExternalIP:   external,
+			}
+			port, err := d.AddPortMapping(proto, f.port, f.port, "gorrent", portMappingLease)
+			if err != nil {
+				m.Error = err.Error()
+			} else {
+				m.ExternalPort = port
+				mapped = append(mapped, upnpMapping{d, proto, port})
+			}
+			mappings = append(mappings, m)
+		}
+	}
+
+	if f.gateway == nil {
+		mappings = append(mappings, PortMapping{
+			Method:       PortMappingNATPMP,
+			InternalPort: f.port,
+			Error:        "gateway padrão não encontrado",
+		})
+	} else if external, err := natpmpExternalAddress(f.gateway); err != nil {
+		mappings = append(mappings, PortMapping{
+			Method:       PortMappingNATPMP,
+			Gateway:      f.gateway.String(),
+			InternalPort: f.port,
+			Error:        err.Error(),
+		})
+	} else {
+		for _, proto := range []string{"TCP", "UDP"} {
+			m := PortMapping{
+				Method:       PortMappingNATPMP,
+				Protocol:     proto,
+				Gateway:      f.gateway.String(),
+				InternalPort: f.port,
+				ExternalIP:   external.String(),
+			}
+			port, err := natpmpMap(f.gateway, proto, f.port, f.port, portMappingLease)
+			if err != nil {
+				m.Error = err.Error()
+			} else {
+				m.ExternalPort = port
+			}
+			mappings = append(mappings, m)
+		}
+	}
+
+	f.mu.Lock()
+	f.mappings = mappings
+	f.upnp = mapped
+	f.mu.Unlock()
+}
+
+// describePortMappings resume os mapeamentos para a interface: os que deram
+// certo ou, se nenhum deu, os erros
+func describePortMappings(mappings []PortMapping) string {
+	if len(mappings) == 0 {
+		return "aguardando o roteador"
+	}
+
+	var mapped, failed []string
+	for _, m := range mappings {
+		if m.Error != "" {
+			failed = append(failed, strings.TrimSpace(m.Method+" "+m.Protocol)+": "+m.Error)
+			continue
+		}
+		desc := fmt.Sprintf("%s %s %d → %d", m.Method, m.Protocol, m.InternalPort, m.ExternalPort)
+		if m.ExternalIP != "" {
+			desc += " em " + m.ExternalIP
+		}
+		mapped = append(mapped, desc)
+	}
+	if len(mapped) > 0 {
+		return strings.Join(mapped, ", ")
+	}
+	return "falhou (" + strings.Join(failed, "; ") + ")"
+}
+
+// upnpGateway retorna o endereço do roteador, ou o identificador quando ele não é conhecido
+func upnpGateway(d upnp.Device) string {
+	if s, ok := d.(*upnp.IGDService); ok {
+		if u, err := url.Parse(s.URL); err == nil && u.Hostname() != "" {
+			return u.Hostname()
+		}
+	}
+	return d.ID()
+}
+
+// natpmpExternalAddress pergunta ao gateway o seu endereço público
+func natpmpExternalAddress(gateway net.IP) (net.IP, error) {
+	resp, err := natpmpRequest(gateway, []byte{0, 0}, 12)
+	if err != nil {
+		return nil, err
+	}
+	return net.IP(resp[8:12]), nil
+}
+
+// natpmpMap pede ao gateway que encaminhe external para a porta interna;
+// lifetime zero remove o mapeamento
+func natpmpMap(gateway net.IP, proto string, internal, external int, lifetime time.Duration) (int, error) {
+	req := make([]byte, 12)
+	req[1] = 1
+	if proto == "TCP" {
+		req[1] = 2
+	}
+	binary.BigEndian.PutUint16(req[4:], uint16(internal))
+	binary.BigEndian.PutUint16(req[6:], uint16(external))
+	binary.BigEndian.PutUint32(req[8:], uint32(lifetime/time.Second))
+
+	resp, err := natpmpRequest(gateway, req, 16)
+	if err != nil {
+		return 0, err
+	}
+	return int(binary.BigEndian.Uint16(resp[10:12])), nil
+}
+
+// natpmpRequest envia um pedido NAT-PMP e retorna a resposta bem-sucedida
+func natpmpRequest(gateway net.IP, req []byte, size int) ([]byte, error) {
+	conn, err := net.DialUDP("udp4", nil, &net.UDPAddr{IP: gateway, Port: natpmpPort})
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	buf := make([]byte, 16)
+	timeout := 250 * time.Millisecond
+	for range natpmpTries {
+		if _, err := conn.Write(req); err != nil {
+			return nil, err
+		}
+		conn.SetReadDeadline(time.Now().Add(timeout))
+		n, err := conn.Read(buf)
+		var netErr net.Error
+		if errors.As(err, &netErr) && netErr.Timeout() {
+			timeout *= 2
+			continue
+		}
+		if errors.Is(err, syscall.ECONNREFUSED) {
+			return nil, errors.New("o gateway não oferece NAT-PMP")
+		}
+		if err != nil {
+			return nil, err
+		}
+		if n < size || buf[0] != 0 || buf[1] != req[1]+128 {
+			continue
+		}
+		if code := binary.BigEndian.Uint16(buf[2:4]); code != 0 {
+			return nil, fmt.Errorf("o gateway recusou o pedido (código %d)", code)
+		}
+		return buf[:n], nil
+	}
+	return nil, errors.New("o gateway não respondeu ao NAT-PMP")
+}
+
+// defaultGateway lê o gateway IPv4 padrão da tabela de rotas do Linux; nos
+// demais sistemas, só o UPnP é usado
+func defaultGateway() (net.IP, error) {
+	data, err := os.ReadFile("/proc/net/route")
+	if err != nil {
+		return nil, errors.New("NAT-PMP só é suportado no Linux, que expõe a tabela de rotas em /proc/net/route")
+	}
+	for _, line := range strings.Split(string(data), "\n")[1:] {
+		fields := strings.Fields(line)
+		if len(fields) < 3 || fields[1] != "00000000" {
+			continue
+		}
+		gw, err := strconv.ParseUint(fields[2], 16, 32)
+		if err != nil || gw == 0 {
+			continue
+		}
+		// O kernel escreve o endereço na ordem de bytes da máquina
+		ip := make(net.IP, net.IPv4len)
+		binary.NativeEndian.PutUint32(ip, uint32(gw))
+		return ip, nil
+	}
+	return nil, errors.New("nenhum gateway padrão encontrado")
+}
@@ -0,0 +1,147 @@
+package downloader
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/alucod3/gorrent/internal/config"
+	"github.com/anacrolix/torrent"
+	"golang.org/x/net/proxy"
+)
+
+// configureProxy faz os anúncios aos trackers passarem pelo proxy configurado.
+// Com ProxyPeers, retorna também o dialer pelo qual o cliente se conecta aos
+// peers; sem ele, o dialer é nil e os peers são acessados diretamente.
+func configureProxy(clientConfig *torrent.ClientConfig, cfg *config.Config) (torrent.Dialer, error) {
+	u, err := cfg.Proxy()
+	if err != nil {
+		return nil, err
+	}
+	if u == nil {
+		if cfg.ProxyPeers {
+			return nil, fmt.Errorf("ProxyPeers exige um proxy em ProxyURL, HTTPS_PROXY ou ALL_PROXY")
+		}
+		return nil, nil
+	}
+
+	// O transporte HTTP já sabe usar proxies HTTP e SOCKS5
+	clientConfig.HTTPProxy = http.ProxyURL(u)
+	if !cfg.ProxyPeers {
+		return nil, nil
+	}
+
+	dialer, err := proxyDialer(u)
+	if err != nil {
+		return nil, err
+	}
+	// Sem sockets próprios para peers, nada sai da máquina sem passar pelo proxy
+	clientConfig.DisableTCP = true
+	clientConfig.DisableUTP = true
+	clientConfig.AcceptPeerConnections = false
+	clientConfig.NoDHT = true
+	clientConfig.DisableWebtorrent = true
+	return torrent.NetworkDialer{Network: "tcp", Dialer: dialer}, nil
+}
+
+// proxiedHTTPClient cria um cliente HTTP para baixar arquivos, como listas de
+// bloqueio e .torrent, pelo proxy configurado, se houver
+func proxiedHTTPClient(cfg *config.Config, timeout time.Duration) (*http.Client, error) {
+	proxyURL, err := cfg.Proxy()
+	if err != nil {
+		return nil, err
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if proxyURL != nil {
+		transport.Proxy = http.ProxyURL(proxyURL)
+	}
+	return &http.Client{Timeout: timeout, Transport: transport}, nil
+}
+
+// proxyDialer cria um dialer que abre conexões TCP através do proxy
+func proxyDialer(u *url.URL) (proxy.ContextDialer, error) {
+	if u.Scheme == "http" {
+		return &httpConnectDialer{proxy: u}, nil
+	}
+
+	d, err := proxy.FromURL(u, proxy.Direct)
+	if err != nil {
+		return nil, fmt.Errorf("erro ao configurar o proxy %s: %w", u.Redacted(), err)
+	}
+	cd, ok := d.(proxy.ContextDialer)
+	if !ok {
+		return nil, fmt.Errorf("proxy %s não suporta conexões com contexto", u.Redacted())
+	}
+	return cd, nil
+}
+
+// httpConnectDialer abre conexões TCP por um proxy HTTP usando o método CONNECT
+type httpConnectDialer struct {
+	proxy *url.URL
+}
+
+// DialContext conecta ao proxy e pede um túnel até addr
+func (d *httpConnectDialer) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	var nd net.Dialer
+	conn, err := nd.DialContext(ctx, "tcp", d.proxy.Host)
+	if err != nil {
+		return nil, err
+	}
+
+	// A negociação também respeita o prazo do contexto
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+
+	req := &http.Request{
+		Method: http.MethodConnect,
+		URL:    &url.URL{Opaque: addr},
+		Host:   addr,
+		Header: make(http.Header),
+	}
+	if user := d.proxy.User; user != nil {
+		password, _ := user.Password()
+		auth := base64.StdEncoding.EncodeToString([]byte(user.Username() + ":" + password))
+		req.Header.Set("Proxy-Authorization", "Basic "+auth)
+	}
+	if err := req.Write(conn); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, req)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("erro na resposta do proxy: %w", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		conn.Close()
+		return nil, fmt.Errorf("proxy recusou a conexão com %s: %s", addr, resp.Status)
+	}
+	if !stop() {
+		conn.Close()
+		return nil, ctx.Err()
+	}
+
+	// Dados do túnel que chegaram junto com a resposta ficaram no buffer
+	if br.Buffered() > 0 {
+		return &bufferedConn{Conn: conn, r: br}, nil
+	}
+	return conn, nil
+}
+
+// bufferedConn lê primeiro o que ficou no buffer e depois da conexão
+type bufferedConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+func (c *bufferedConn) Read(p []byte) (int, error) {
+	return c.r.Read(p)
+}
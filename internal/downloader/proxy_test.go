@@ -0,0 +1,451 @@
+package downloader
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alucod3/gorrent/internal/config"
+	"github.com/anacrolix/log"
+	"github.com/anacrolix/torrent"
+	"github.com/anacrolix/torrent/bencode"
+	"github.com/anacrolix/torrent/metainfo"
+	"github.com/anacrolix/torrent/tracker"
+)
+
+// proxyLog guarda os destinos pedidos a um proxy de teste
+type proxyLog struct {
+	mu      sync.Mutex
+	targets []string
+}
+
+func (l *proxyLog) add(target string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.targets = append(l.targets, target)
+}
+
+func (l *proxyLog) contains(target string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, t := range l.targets {
+		if t == target {
+			return true
+		}
+	}
+	return false
+}
+
+// startHTTPProxy sobe um proxy HTTP que atende CONNECT e encaminha requisições
+// comuns. Com user, exige autenticação básica; connectStatus, se diferente de
+// 200, é a resposta a todo CONNECT.
+func startHTTPProxy(t *testing.T, user, password string, connectStatus int) (*url.URL, *proxyLog) {
+	t.Helper()
+	log := &proxyLog{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if user != "" {
+			want := "Basic " + base64.StdEncoding.EncodeToString([]byte(user+":"+password))
+			if r.Header.Get("Proxy-Authorization") != want {
+				w.WriteHeader(http.StatusProxyAuthRequired)
+				return
+			}
+		}
+
+		if r.Method != http.MethodConnect {
+			log.add(r.URL.String())
+			out := r.Clone(r.Context())
+			out.RequestURI = ""
+			out.Header.Del("Proxy-Authorization")
+			resp, err := http.DefaultTransport.RoundTrip(out)
+			if err != nil {
+				w.WriteHeader(http.StatusBadGateway)
+				return
+			}
+			defer resp.Body.Close()
+			w.WriteHeader(resp.StatusCode)
+			io.Copy(w, resp.Body)
+			return
+		}
+
+		log.add(r.Host)
+		if connectStatus != http.StatusOK {
+			w.WriteHeader(connectStatus)
+			return
+		}
+		target, err := net.Dial("tcp", r.Host)
+		if err != nil {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		conn, _, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			target.Close()
+			return
+		}
+		io.WriteString(conn, "HTTP/1.1 200 OK\r\n\r\n")
+		splice(conn, target)
+	}))
+	t.Cleanup(srv.Close)
+
+	u, _ := url.Parse(srv.URL)
+	if user != "" {
+		u.User = url.UserPassword(user, password)
+	}
+	return u, log
+}
+
+// startSOCKS5Proxy sobe um proxy SOCKS5 mínimo, só com CONNECT; com user,
+// exige autenticação por usuário e senha
+func startSOCKS5Proxy(t *testing.T, user, password string) (*url.URL, *proxyLog) {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+
+	log := &proxyLog{}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go serveSOCKS5(conn, user, password, log)
+		}
+	}()
+
+	u := &url.URL{Scheme: "socks5", Host: l.Addr().String()}
+	if user != "" {
+		u.User = url.UserPassword(user, password)
+	}
+	return u, log
+}
+
+func serveSOCKS5(conn net.Conn, user, password string, log *proxyLog) {
+	r := bufio.NewReader(conn)
+	head := make([]byte, 2)
+	if _, err := io.ReadFull(r, head); err != nil || head[0] != 5 {
+		conn.Close()
+		return
+	}
+	methods := make([]byte, head[1])
+	if _, err := io.ReadFull(r, methods); err != nil {
+		conn.Close()
+		return
+	}
+
+	if user == "" {
+		conn.Write([]byte{5, 0})
+	} else {
+		conn.Write([]byte{5, 2})
+		// Subnegociação da RFC 1929: versão, usuário e senha
+		var ver, n [1]byte
+		io.ReadFull(r, ver[:])
+		io.ReadFull(r, n[:])
+		u := make([]byte, n[0])
+		io.ReadFull(r, u)
+		io.ReadFull(r, n[:])
+		p := make([]byte, n[0])
+		io.ReadFull(r, p)
+		if string(u) != user || string(p) != password {
+			conn.Write([]byte{1, 1})
+			conn.Close()
+			return
+		}
+		conn.Write([]byte{1, 0})
+	}
+
+	req := make([]byte, 4)
+	if _, err := io.ReadFull(r, req); err != nil || req[1] != 1 {
+		conn.Close()
+		return
+	}
+	var host string
+	switch req[3] {
+	case 1:
+		ip := make([]byte, 4)
+		io.ReadFull(r, ip)
+		host = net.IP(ip).String()
+	case 4:
+		ip := make([]byte, 16)
+		io.ReadFull(r, ip)
+		host = net.IP(ip).String()
+	case 3:
+		var n [1]byte
+		io.ReadFull(r, n[:])
+		name := make([]byte, n[0])
+		io.ReadFull(r, name)
+		host = string(name)
+	}
+	var port [2]byte
+	io.ReadFull(r, port[:])
+	target := net.JoinHostPort(host, strconv.Itoa(int(binary.BigEndian.Uint16(port[:]))))
+	log.add(target)
+
+	out, err := net.Dial("tcp", target)
+	if err != nil {
+		conn.Write([]byte{5, 5, 0, 1, 0, 0, 0, 0, 0, 0})
+		conn.Close()
+		return
+	}
+	conn.Write([]byte{5, 0, 0, 1, 0, 0, 0, 0, 0, 0})
+	splice(&bufferedConn{Conn: conn, r: r}, out)
+}
+
+// splice copia os dados entre as duas conexões até uma delas fechar
+func splice(a, b net.Conn) {
+	go func() {
+		io.Copy(a, b)
+		a.Close()
+	}()
+	io.Copy(b, a)
+	b.Close()
+}
+
+// startEcho sobe um servidor TCP que devolve o que recebe, no lugar de um peer
+func startEcho(t *testing.T) string {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				io.Copy(conn, conn)
+				conn.Close()
+			}()
+		}
+	}()
+	return l.Addr().String()
+}
+
+// startTracker sobe um tracker HTTP que responde a todo anúncio sem peers
+func startTracker(t *testing.T) string {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "d8:completei3e10:incompletei1e8:intervali1800e5:peers0:e")
+	}))
+	t.Cleanup(srv.Close)
+	return srv.URL + "/announce"
+}
+
+// proxyConfig monta a configuração do cliente como o engine faz para o proxy
+func proxyConfig(t *testing.T, proxyURL *url.URL, peers bool) (*torrent.ClientConfig, torrent.Dialer) {
+	t.Helper()
+	cfg := &config.Config{ProxyURL: proxyURL.String(), ProxyPeers: peers}
+	clientConfig := torrent.NewDefaultClientConfig()
+	clientConfig.Logger = log.Default
+	dialer, err := configureProxy(clientConfig, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return clientConfig, dialer
+}
+
+// announceThrough anuncia ao tracker com a configuração de proxy informada
+func announceThrough(clientConfig *torrent.ClientConfig, trackerURL string) (tracker.AnnounceResponse, error) {
+	a := &announcer{set: newTrackerSet(nil, clientConfig, false)}
+	req := tracker.AnnounceRequest{NumWant: 50, Left: -1, Port: 6881}
+	return a.do(context.Background(), trackerURL, req)
+}
+
+// dialEcho conecta ao servidor de eco pelo dialer e confere a resposta
+func dialEcho(t *testing.T, dialer torrent.Dialer, addr string) {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	conn, err := dialer.Dial(ctx, addr)
+	if err != nil {
+		t.Fatalf("conexão ao peer pelo proxy: %v", err)
+	}
+	defer conn.Close()
+
+	if _, err := io.WriteString(conn, "ping"); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 4)
+	if _, err := io.ReadFull(conn, buf); err != nil || string(buf) != "ping" {
+		t.Fatalf("eco pelo proxy: %q, %v", buf, err)
+	}
+}
+
+func TestProxyTrackerAndPeers(t *testing.T) {
+	trackerURL := startTracker(t)
+	peer := startEcho(t)
+
+	tests := []struct {
+		name  string
+		start func(t *testing.T) (*url.URL, *proxyLog)
+		// trackerTarget é como o proxy registra o anúncio HTTP
+		trackerTarget func(trackerURL string) string
+	}{
+		{
+			name: "http",
+			start: func(t *testing.T) (*url.URL, *proxyLog) {
+				return startHTTPProxy(t, "user", "secret", http.StatusOK)
+			},
+			trackerTarget: func(u string) string { return u },
+		},
+		{
+			name: "socks5",
+			start: func(t *testing.T) (*url.URL, *proxyLog) {
+				return startSOCKS5Proxy(t, "user", "secret")
+			},
+			trackerTarget: func(u string) string {
+				parsed, _ := url.Parse(u)
+				return parsed.Host
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			proxyURL, log := tt.start(t)
+			clientConfig, dialer := proxyConfig(t, proxyURL, true)
+			if dialer == nil {
+				t.Fatal("ProxyPeers sem dialer para os peers")
+			}
+			if !clientConfig.DisableTCP || !clientConfig.DisableUTP || !clientConfig.NoDHT || clientConfig.AcceptPeerConnections {
+				t.Error("ProxyPeers deixou sockets próprios do cliente ativos")
+			}
+
+			res, err := announceThrough(clientConfig, trackerURL)
+			if err != nil {
+				t.Fatalf("anúncio pelo proxy: %v", err)
+			}
+			if res.Seeders != 3 || res.Leechers != 1 {
+				t.Errorf("resposta do tracker: %+v", res)
+			}
+			if target := tt.trackerTarget(strings.SplitN(trackerURL, "?", 2)[0]); !logContainsPrefix(log, target) {
+				t.Errorf("anúncio não passou pelo proxy: %v", log.targets)
+			}
+
+			dialEcho(t, dialer, peer)
+			if !log.contains(peer) {
+				t.Errorf("conexão ao peer não passou pelo proxy: %v", log.targets)
+			}
+		})
+	}
+}
+
+// logContainsPrefix indica se algum destino registrado começa com prefix,
+// já que o anúncio HTTP leva a query string
+func logContainsPrefix(l *proxyLog, prefix string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, t := range l.targets {
+		if strings.HasPrefix(t, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func TestProxyErrors(t *testing.T) {
+	peer := startEcho(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	t.Run("http recusa", func(t *testing.T) {
+		proxyURL, _ := startHTTPProxy(t, "", "", http.StatusForbidden)
+		_, dialer := proxyConfig(t, proxyURL, true)
+		_, err := dialer.Dial(ctx, peer)
+		if err == nil || !strings.Contains(err.Error(), "403") {
+			t.Fatalf("esperado erro com a resposta do proxy, veio %v", err)
+		}
+	})
+
+	t.Run("http autenticação", func(t *testing.T) {
+		proxyURL, _ := startHTTPProxy(t, "user", "secret", http.StatusOK)
+		proxyURL.User = url.UserPassword("user", "wrong")
+		_, dialer := proxyConfig(t, proxyURL, true)
+		_, err := dialer.Dial(ctx, peer)
+		if err == nil || !strings.Contains(err.Error(), "407") {
+			t.Fatalf("esperado erro de autenticação, veio %v", err)
+		}
+	})
+
+	t.Run("socks5 autenticação", func(t *testing.T) {
+		proxyURL, log := startSOCKS5Proxy(t, "user", "secret")
+		proxyURL.User = url.UserPassword("user", "wrong")
+		_, dialer := proxyConfig(t, proxyURL, true)
+		if _, err := dialer.Dial(ctx, peer); err == nil {
+			t.Fatal("esperado erro de autenticação")
+		}
+		if log.contains(peer) {
+			t.Error("proxy conectou ao peer sem autenticação")
+		}
+	})
+
+	t.Run("udp com peers pelo proxy", func(t *testing.T) {
+		proxyURL, _ := startHTTPProxy(t, "", "", http.StatusOK)
+		clientConfig, _ := proxyConfig(t, proxyURL, true)
+		a := &announcer{set: newTrackerSet(nil, clientConfig, true)}
+		_, err := a.do(ctx, "udp://127.0.0.1:1/announce", tracker.AnnounceRequest{})
+		if err == nil || !strings.Contains(err.Error(), "não passam pelo proxy") {
+			t.Fatalf("esperado tracker UDP recusado, veio %v", err)
+		}
+	})
+}
+
+func TestProxyTorrentFetch(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "data.bin"), []byte("conteúdo de teste"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	info := metainfo.Info{PieceLength: 16 << 10}
+	if err := info.BuildFromFilePath(filepath.Join(dir, "data.bin")); err != nil {
+		t.Fatal(err)
+	}
+	var mi metainfo.MetaInfo
+	var err error
+	if mi.InfoBytes, err = bencode.Marshal(info); err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/data.torrent" {
+			http.NotFound(w, r)
+			return
+		}
+		mi.Write(w)
+	}))
+	defer srv.Close()
+
+	proxyURL, log := startHTTPProxy(t, "user", "secret", http.StatusOK)
+	e := &engine{config: &config.Config{ProxyURL: proxyURL.String()}}
+
+	got, err := e.fetchTorrent(srv.URL + "/data.torrent")
+	if err != nil {
+		t.Fatalf("download do .torrent pelo proxy: %v", err)
+	}
+	if got.HashInfoBytes() != mi.HashInfoBytes() {
+		t.Error("info hash do .torrent baixado não confere")
+	}
+	if !log.contains(srv.URL + "/data.torrent") {
+		t.Errorf("download não passou pelo proxy: %v", log.targets)
+	}
+
+	if _, err := e.fetchTorrent(srv.URL + "/missing.torrent"); err == nil || !strings.Contains(err.Error(), "404") {
+		t.Errorf("esperado erro com a resposta do servidor, veio %v", err)
+	}
+}
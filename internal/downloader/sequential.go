@@ -0,0 +1,90 @@
+package downloader
+
+import (
+	"fmt"
+
+	"github.com/anacrolix/torrent"
+	"github.com/anacrolix/torrent/types"
+)
+
+// sequencer baixa um arquivo em ordem: a primeira peça que falta recebe a maior
+// prioridade e uma janela de peças à frente dela vem em seguida
+type sequencer struct {
+	t      *torrent.Torrent
+	file   *torrent.File
+	window int
+	next   int
+}
+
+// newSequencer prepara o download em ordem do arquivo escolhido nas opções,
+// ou do maior arquivo do torrent; retorna nil fora do modo sequencial
+func newSequencer(t *torrent.Torrent, opts Options, readahead int64) (*sequencer, error) {
+	if !opts.Sequential {
+		return nil, nil
+	}
+
+	files := t.Files()
+	index := largestFile(files)
+	if opts.SequentialFile != nil {
+		index = *opts.SequentialFile
+		if index < 0 || index >= len(files) {
+			return nil, fmt.Errorf("o torrent tem %d arquivos, índice %d não existe", len(files), index)
+		}
+	}
+
+	f := files[index]
+	if f.Priority() == types.PiecePriorityNone {
+		return nil, fmt.Errorf("o arquivo %d está ignorado e não pode ser baixado em ordem", index)
+	}
+
+	window := int((readahead + t.Info().PieceLength - 1) / t.Info().PieceLength)
+	s := &sequencer{
+		t:      t,
+		file:   f,
+		window: max(window, 1),
+		next:   f.BeginPieceIndex(),
+	}
+	s.update()
+	return s, nil
+}
+
+// largestFile retorna o índice do maior arquivo
+func largestFile(files []*torrent.File) int {
+	largest := 0
+	for i, f := range files {
+		if f.Length() > files[largest].Length() {
+			largest = i
+		}
+	}
+	return largest
+}
+
+// update avança a janela até a primeira peça que ainda falta no arquivo.
+// As peças anteriores já estão completas e as da janela mantêm a prioridade
+// até serem concluídas, então basta priorizar as que entram na janela.
+func (s *sequencer) update() {
+	if s == nil {
+		return
+	}
+
+	end := s.file.EndPieceIndex()
+	for s.next < end && s.t.PieceState(s.next).Complete {
+		s.next++
+	}
+
+	for i := s.next; i < min(s.next+s.window, end); i++ {
+		switch i {
+		case s.next:
+			s.t.Piece(i).SetPriority(types.PiecePriorityNow)
+		case s.next + 1:
+			s.t.Piece(i).SetPriority(types.PiecePriorityNext)
+		default:
+			s.t.Piece(i).SetPriority(types.PiecePriorityReadahead)
+		}
+	}
+}
+
+// path retorna o caminho do arquivo baixado em ordem
+func (s *sequencer) path() string {
+	return s.file.DisplayPath()
+}
@@ -0,0 +1,327 @@
+package downloader
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/anacrolix/torrent"
+	"github.com/anacrolix/torrent/bencode"
+	"github.com/anacrolix/torrent/metainfo"
+)
+
+// SessionStatus descreve os torrents restaurados da sessão anterior do daemon
+// e a última gravação da sessão
+type SessionStatus struct {
+	Restored int `json:"restored"`
+	// Quarantined é quantas entradas não puderam ser restauradas e foram
+	// separadas no arquivo de quarentena
+	Quarantined int       `json:"quarantined"`
+	Saved       time.Time `json:"saved"`
+	Error       string    `json:"error,omitempty"`
+}
+
+// sessionEntry é um torrent do daemon guardado para ser restaurado
+type sessionEntry struct {
+	InfoHash string `json:"info_hash"`
+	// MetaInfo é o .torrent em bencode; vazio enquanto um magnet não recebeu os
+	// metadados, e então o torrent é readicionado pelo Link
+	MetaInfo []byte    `json:"metainfo,omitempty"`
+	Link     string    `json:"link,omitempty"`
+	Options  Options   `json:"options"`
+	Added    time.Time `json:"added"`
+	// Dir é onde estão os dados quando não ficam no diretório de incompletos;
+	// Existing indica que é uma cópia já baixada que está sendo semeada
+	Dir       string `json:"dir,omitempty"`
+	Existing  bool   `json:"existing,omitempty"`
+	Paused    bool   `json:"paused,omitempty"`
+	Completed bool   `json:"completed,omitempty"`
+	Moved     bool   `json:"moved,omitempty"`
+	SeedDone  bool   `json:"seed_done,omitempty"`
+	Uploaded  int64  `json:"uploaded,omitempty"`
+}
+
+// session guarda os torrents do daemon em um arquivo JSON Lines, um por linha,
+// para que uma entrada corrompida não impeça a restauração das demais
+type session struct {
+	path       string
+	quarantine string
+}
+
+// save grava as entradas em um arquivo temporário e o troca pelo atual, para
+// que uma interrupção no meio da gravação não perca a sessão
+func (s *session) save(entries []sessionEntry) error {
+	if err := os.MkdirAll(filepath.Dir(s.path), 0755); err != nil {
+		return err
+	}
+
+	var buf bytes.Buffer
+	for _, e := range entries {
+		data, err := json.Marshal(e)
+		if err != nil {
+			return err
+		}
+		buf.Write(data)
+		buf.WriteByte('\n')
+	}
+
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, buf.Bytes(), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.path)
+}
+
+// rejectedEntry é uma linha da sessão que não pôde ser restaurada
+type rejectedEntry struct {
+	line []byte
+	err  error
+}
+
+// load lê as entradas da sessão, separando as linhas que não puderem ser lidas
+func (s *session) load() ([]sessionEntry, []rejectedEntry, error) {
+	f, err := os.Open(s.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil, nil
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	defer f.Close()
+
+	var (
+		entries  []sessionEntry
+		rejected []rejectedEntry
+	)
+	scanner := bufio.NewScanner(f)
+	// O metainfo de torrents com muitas peças ocupa vários megabytes
+	scanner.Buffer(make([]byte, 0, 64<<10), 64<<20)
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		if len(bytes.TrimSpace(line)) == 0 {
+			continue
+		}
+		var e sessionEntry
+		err := json.Unmarshal(line, &e)
+		if err == nil && len(e.MetaInfo) == 0 && e.Link == "" {
+			err = errors.New("entrada sem metainfo nem link")
+		}
+		if err != nil {
+			rejected = append(rejected, rejectedEntry{bytes.Clone(line), err})
+			continue
+		}
+		entries = append(entries, e)
+	}
+	return entries, rejected, scanner.Err()
+}
+
+// isolate acrescenta uma entrada que não pôde ser restaurada à quarentena,
+// com o motivo, para que não seja perdida nem impeça o daemon de iniciar
+func (s *session) isolate(line []byte, reason error) error {
+	data, err := json.Marshal(struct {
+		Time  time.Time `json:"time"`
+		Error string    `json:"error"`
+		Entry string    `json:"entry"`
+	}{time.Now(), reason.Error(), string(line)})
+	if err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(s.quarantine, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(append(data, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// sessionEntry descreve um torrent para a sessão; deve ser chamado com o
+// mutex travado
+func (m *Manager) sessionEntry(id string, tk *task) (sessionEntry, error) {
+	e := sessionEntry{
+		InfoHash:  id,
+		Link:      tk.link,
+		Options:   tk.opts,
+		Added:     tk.started,
+		Paused:    tk.paused,
+		Completed: tk.completed,
+		Moved:     tk.moved,
+		SeedDone:  tk.seedDone,
+		Uploaded:  tk.baseUploaded + tk.lastWritten,
+	}
+
+	// O metainfo é guardado uma vez; trackers acrescentados o invalidam
+	if tk.metainfo == nil && tk.t.Info() != nil {
+		mi := tk.t.Metainfo()
+		if isPrivate(tk.t) {
+			// Os trackers extras de um magnet privado voltariam como se fossem
+			// do próprio torrent
+			extra := m.engine.trackers.extra(tk.t.InfoHash())
+			mi.AnnounceList = withoutTrackers(mi.UpvertedAnnounceList(), extra)
+			if extra[mi.Announce] {
+				mi.Announce = ""
+			}
+		}
+		data, err := bencode.Marshal(mi)
+		if err != nil {
+			return e, err
+		}
+		tk.metainfo = data
+	}
+	e.MetaInfo = tk.metainfo
+
+	// Sem arquivos comuns, os dados ficam no armazenamento, não em um diretório
+	if m.engine.backend.PlainFiles() {
+		switch {
+		case tk.existing != "":
+			e.Dir, e.Existing = tk.existing, true
+		case tk.moved:
+			e.Dir = destinationDir(m.config, tk.category)
+		}
+		if e.Dir == m.config.IncompleteDir() {
+			e.Dir, e.Existing = "", false
+		}
+	}
+	return e, nil
+}
+
+// saveSession grava o estado de todos os torrents; deve ser chamado com o
+// mutex travado. Uma falha fica registrada nas estatísticas e a gravação é
+// tentada de novo na próxima mudança.
+func (m *Manager) saveSession() {
+	entries := make([]sessionEntry, 0, len(m.tasks))
+	var err error
+	for id, tk := range m.tasks {
+		// Torrents pulados por já terem sido baixados não chegaram a ser adicionados
+		if errors.Is(tk.err, ErrDuplicate) {
+			continue
+		}
+		var e sessionEntry
+		if e, err = m.sessionEntry(id, tk); err != nil {
+			break
+		}
+		entries = append(entries, e)
+	}
+	if err == nil {
+		sort.Slice(entries, func(i, j int) bool {
+			return entries[i].Added.Before(entries[j].Added)
+		})
+		err = m.session.save(entries)
+	}
+
+	m.dirty = err != nil
+	m.sessionStatus.Error = ""
+	if err != nil {
+		m.sessionStatus.Error = err.Error()
+		return
+	}
+	m.sessionStatus.Saved = time.Now()
+}
+
+// restoreSession readiciona os torrents da sessão anterior com suas opções e
+// estado. Entradas que falharem vão para a quarentena em vez de impedir o
+// daemon de iniciar.
+func (m *Manager) restoreSession() error {
+	entries, rejected, err := m.session.load()
+	if err != nil {
+		return fmt.Errorf("erro ao ler a sessão %s: %w", m.session.path, err)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, e := range entries {
+		if _, ok := m.tasks[e.InfoHash]; ok {
+			continue
+		}
+		t, err := m.restoreTorrent(e)
+		if err != nil {
+			line, _ := json.Marshal(e)
+			rejected = append(rejected, rejectedEntry{line, err})
+			continue
+		}
+
+		// Um torrent concluído que não chegou a ser movido, por o daemon ter
+		// parado no meio, volta como incompleto: ao ser detectado como concluído
+		// de novo, é movido e dispara os hooks
+		tk := &task{
+			t:            t,
+			link:         e.Link,
+			opts:         e.Options,
+			started:      e.Added,
+			paused:       e.Paused,
+			completed:    e.Completed && e.Moved,
+			moved:        e.Moved,
+			seedDone:     e.SeedDone,
+			baseUploaded: e.Uploaded,
+			restored:     true,
+		}
+		if e.Existing {
+			tk.existing = e.Dir
+		}
+		if tk.paused {
+			t.DisallowDataDownload()
+			m.engine.throttle.disallowUpload(t)
+		}
+		m.tasks[t.InfoHash().HexString()] = tk
+		m.watchErrors(tk)
+		go m.start(tk)
+		m.sessionStatus.Restored++
+	}
+
+	if len(rejected) == 0 {
+		return nil
+	}
+
+	// As entradas em quarentena deixam o arquivo da sessão; se não puderem ser
+	// separadas, o erro fica nas estatísticas e o arquivo não é regravado agora
+	for _, r := range rejected {
+		if err := m.session.isolate(r.line, r.err); err != nil {
+			m.sessionStatus.Error = fmt.Sprintf("erro ao separar entrada da sessão: %v", err)
+			return nil
+		}
+		m.sessionStatus.Quarantined++
+	}
+	m.saveSession()
+	return nil
+}
+
+// restoreTorrent adiciona ao cliente o torrent de uma entrada da sessão
+func (m *Manager) restoreTorrent(e sessionEntry) (*torrent.Torrent, error) {
+	if err := e.Options.validate(m.config); err != nil {
+		return nil, err
+	}
+
+	// Um magnet sem metadados volta a ser adicionado como da primeira vez
+	if len(e.MetaInfo) == 0 {
+		t, err := m.engine.add(e.Link)
+		if err != nil {
+			return nil, err
+		}
+		if err := m.engine.trackers.add(t, e.Options.Trackers); err != nil {
+			m.engine.remove(t)
+			return nil, err
+		}
+		t.AddWebSeeds(e.Options.WebSeeds)
+		return t, nil
+	}
+
+	// O metainfo já inclui os trackers e web seeds acrescentados
+	mi, err := metainfo.Load(bytes.NewReader(e.MetaInfo))
+	if err != nil {
+		return nil, fmt.Errorf("metainfo inválido: %w", err)
+	}
+	if e.Dir == "" {
+		return m.engine.addMetaInfo(mi)
+	}
+	return m.engine.addStored(mi, e.Dir)
+}
@@ -0,0 +1,82 @@
+package downloader
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/anacrolix/torrent/storage"
+)
+
+// Backends de armazenamento incluídos
+const (
+	StorageFile   = "file"
+	StorageMMap   = "mmap"
+	StorageSQLite = "sqlite"
+	StorageMemory = "memory"
+)
+
+// StorageBackend cria o armazenamento onde as peças dos torrents são gravadas
+type StorageBackend interface {
+	// Open abre o armazenamento usando o diretório de dados informado
+	Open(dir string) (storage.ClientImplCloser, error)
+	// PlainFiles indica se os dados ficam em arquivos comuns dentro do
+	// diretório, que podem ser movidos quando o download termina
+	PlainFiles() bool
+}
+
+var (
+	backendsMu sync.RWMutex
+	backends   = map[string]StorageBackend{
+		StorageFile:   fileBackend{},
+		StorageMMap:   mmapBackend{},
+		StorageMemory: memoryBackend{},
+	}
+)
+
+// RegisterStorage registra um backend de armazenamento, que passa a poder ser
+// escolhido pelo nome na opção Storage da configuração
+func RegisterStorage(name string, backend StorageBackend) {
+	backendsMu.Lock()
+	defer backendsMu.Unlock()
+	backends[name] = backend
+}
+
+// lookupStorage retorna o backend registrado com o nome informado
+func lookupStorage(name string) (StorageBackend, error) {
+	backendsMu.RLock()
+	defer backendsMu.RUnlock()
+
+	if name == "" {
+		name = StorageFile
+	}
+	if backend, ok := backends[name]; ok {
+		return backend, nil
+	}
+
+	names := make([]string, 0, len(backends))
+	for n := range backends {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	return nil, fmt.Errorf("armazenamento desconhecido %q: use %s", name, strings.Join(names, ", "))
+}
+
+// fileBackend grava cada arquivo do torrent como um arquivo comum
+type fileBackend struct{}
+
+func (fileBackend) Open(dir string) (storage.ClientImplCloser, error) {
+	return storage.NewFile(dir), nil
+}
+
+func (fileBackend) PlainFiles() bool { return true }
+
+// mmapBackend grava nos mesmos arquivos, mas acessando-os por mapeamento em memória
+type mmapBackend struct{}
+
+func (mmapBackend) Open(dir string) (storage.ClientImplCloser, error) {
+	return storage.NewMMap(dir), nil
+}
+
+func (mmapBackend) PlainFiles() bool { return true }
@@ -0,0 +1,115 @@
+package downloader
+
+import (
+	"context"
+	"io"
+	"sync"
+
+	"github.com/anacrolix/torrent/metainfo"
+	"github.com/anacrolix/torrent/storage"
+)
+
+// memoryBackend mantém as peças apenas na memória; os dados se perdem ao
+// encerrar, o que serve para testes e streaming sem deixar rastros no disco
+type memoryBackend struct{}
+
+func (memoryBackend) Open(string) (storage.ClientImplCloser, error) {
+	return memoryStorage{}, nil
+}
+
+func (memoryBackend) PlainFiles() bool { return false }
+
+// memoryStorage abre um conjunto de peças em memória para cada torrent
+type memoryStorage struct{}
+
+func (memoryStorage) OpenTorrent(_ context.Context, _ *metainfo.Info, _ metainfo.Hash) (storage.TorrentImpl, error) {
+	mt := &memoryTorrent{pieces: make(map[int]*memoryPiece)}
+	return storage.TorrentImpl{Piece: mt.piece, Close: mt.close}, nil
+}
+
+func (memoryStorage) Close() error { return nil }
+
+// memoryTorrent guarda as peças de um torrent
+type memoryTorrent struct {
+	mu     sync.Mutex
+	pieces map[int]*memoryPiece
+}
+
+func (mt *memoryTorrent) piece(p metainfo.Piece) storage.PieceImpl {
+	mt.mu.Lock()
+	defer mt.mu.Unlock()
+
+	mp, ok := mt.pieces[p.Index()]
+	if !ok {
+		mp = &memoryPiece{length: p.Length()}
+		mt.pieces[p.Index()] = mp
+	}
+	return mp
+}
+
+func (mt *memoryTorrent) close() error {
+	mt.mu.Lock()
+	defer mt.mu.Unlock()
+	mt.pieces = make(map[int]*memoryPiece)
+	return nil
+}
+
+// memoryPiece só aloca o buffer na primeira escrita, já que o cliente
+// consulta todas as peças ao abrir o torrent
+type memoryPiece struct {
+	mu       sync.RWMutex
+	length   int64
+	data     []byte
+	complete bool
+}
+
+func (mp *memoryPiece) ReadAt(b []byte, off int64) (int, error) {
+	mp.mu.RLock()
+	defer mp.mu.RUnlock()
+
+	if off >= int64(len(mp.data)) {
+		return 0, io.EOF
+	}
+	n := copy(b, mp.data[off:])
+	if n < len(b) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+func (mp *memoryPiece) WriteAt(b []byte, off int64) (int, error) {
+	mp.mu.Lock()
+	defer mp.mu.Unlock()
+
+	if mp.data == nil {
+		mp.data = make([]byte, mp.length)
+	}
+	if off >= int64(len(mp.data)) {
+		return 0, io.ErrShortWrite
+	}
+	n := copy(mp.data[off:], b)
+	if n < len(b) {
+		return n, io.ErrShortWrite
+	}
+	return n, nil
+}
+
+func (mp *memoryPiece) MarkComplete() error {
+	mp.mu.Lock()
+	defer mp.mu.Unlock()
+	mp.complete = true
+	return nil
+}
+
+func (mp *memoryPiece) MarkNotComplete() error {
+	mp.mu.Lock()
+	defer mp.mu.Unlock()
+	mp.complete = false
+	return nil
+}
+
+func (mp *memoryPiece) Completion() storage.Completion {
+	mp.mu.RLock()
+	defer mp.mu.RUnlock()
+	return storage.Completion{Complete: mp.complete, Ok: true}
+}
@@ -0,0 +1,29 @@
+//go:build cgo
+
+package downloader
+
+import (
+	"path/filepath"
+
+	"github.com/anacrolix/torrent/storage"
+	sqliteStorage "github.com/anacrolix/torrent/storage/sqlite"
+)
+
+// sqliteDatabase é o nome do banco com as peças dentro do diretório de dados
+const sqliteDatabase = "gorrent-pieces.db"
+
+// O SQLite depende de cgo, então o backend só existe quando ele está disponível
+func init() {
+	RegisterStorage(StorageSQLite, sqliteBackend{})
+}
+
+// sqliteBackend guarda as peças como blobs em um banco SQLite
+type sqliteBackend struct{}
+
+func (sqliteBackend) Open(dir string) (storage.ClientImplCloser, error) {
+	var opts sqliteStorage.NewDirectStorageOpts
+	opts.Path = filepath.Join(dir, sqliteDatabase)
+	return sqliteStorage.NewDirectStorage(opts)
+}
+
+func (sqliteBackend) PlainFiles() bool { return false }
@@ -0,0 +1,99 @@
+package downloader
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/alucod3/gorrent/internal/config"
+	"github.com/anacrolix/torrent"
+	"github.com/anacrolix/torrent/types"
+)
+
+// Streamer serve um arquivo de um torrent por HTTP enquanto ele é baixado
+type Streamer struct {
+	config *config.Config
+	engine *engine
+	file   *torrent.File
+}
+
+// NewStreamer cria um streamer com um cliente torrent próprio
+func NewStreamer(cfg *config.Config) (*Streamer, error) {
+	if err := cfg.EnsureDownloadPath(); err != nil {
+		return nil, fmt.Errorf("erro ao criar diretório de download: %w", err)
+	}
+
+	e, err := newEngine(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return &Streamer{config: cfg, engine: e}, nil
+}
+
+// Close encerra o cliente torrent
+func (s *Streamer) Close() {
+	s.engine.Close()
+}
+
+// Open adiciona o torrent, aguarda os metadados e escolhe o arquivo servido:
+// o do índice informado ou o maior. Os demais arquivos são ignorados.
+func (s *Streamer) Open(ctx context.Context, link string, index *int) (*torrent.File, error) {
+	t, err := s.engine.add(link)
+	if err != nil {
+		return nil, err
+	}
+
+	select {
+	case <-t.GotInfo():
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+	files := t.Files()
+	i := largestFile(files)
+	if index != nil {
+		i = *index
+		if i < 0 || i >= len(files) {
+			return nil, fmt.Errorf("o torrent tem %d arquivos, índice %d não existe", len(files), i)
+		}
+	}
+
+	// O arquivo escolhido continua sendo baixado em segundo plano, mas as
+	// leituras priorizam as peças na posição pedida pelo cliente HTTP
+	for j, f := range files {
+		if j == i {
+			f.SetPriority(types.PiecePriorityNormal)
+		} else {
+			f.SetPriority(types.PiecePriorityNone)
+		}
+	}
+
+	s.file = files[i]
+	return s.file, nil
+}
+
+// ServeHTTP serve o arquivo escolhido com suporte a requisições Range
+func (s *Streamer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if s.file == nil {
+		http.Error(w, "nenhum arquivo aberto", http.StatusServiceUnavailable)
+		return
+	}
+
+	reader := s.file.NewReader()
+	defer reader.Close()
+	reader.SetReadahead(s.config.SequentialReadahead)
+
+	// http.ServeContent cuida de Range, HEAD e do tipo de conteúdo pela extensão
+	http.ServeContent(w, r, s.file.DisplayPath(), time.Time{}, contextReader{reader, r.Context()})
+}
+
+// contextReader interrompe leituras à espera de peças quando o cliente HTTP desconecta
+type contextReader struct {
+	torrent.Reader
+	ctx context.Context
+}
+
+func (r contextReader) Read(b []byte) (int, error) {
+	return r.ReadContext(r.ctx, b)
+}
@@ -0,0 +1,305 @@
+package downloader
+
+import (
+	"context"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/alucod3/gorrent/internal/config"
+	g "github.com/anacrolix/generics"
+	"github.com/anacrolix/torrent"
+	"github.com/anacrolix/torrent/metainfo"
+	"github.com/anacrolix/torrent/storage"
+	"golang.org/x/time/rate"
+)
+
+// minBurst garante que um bloco inteiro caiba no balde mesmo com limites baixos
+const minBurst = 64 << 10
+
+// uploadCheckInterval é de quanto em quanto tempo o envio aos peers é medido
+const uploadCheckInterval = 250 * time.Millisecond
+
+// limits são os limitadores de taxa compartilhados pelos torrents de uma categoria
+type limits struct {
+	download *rate.Limiter
+	upload   *rate.Limiter
+}
+
+// throttle limita a taxa de cada torrent conforme a sua categoria. O cliente
+// só oferece limites globais, então o download é limitado na escrita dos dados
+// no armazenamento. O upload não pode ser limitado na leitura, que também
+// atende o streaming, então os bytes de fato enviados aos peers são medidos e
+// o envio do torrent fica bloqueado enquanto a categoria passa do limite.
+type throttle struct {
+	mu         sync.Mutex
+	categories map[string]*limits
+	torrents   map[metainfo.Hash]*limits
+
+	// uploads guarda, por torrent, se o envio é permitido por quem o controla
+	// e se está bloqueado pelo limite; uploadMu não é usado na leitura e
+	// escrita das peças, e pode ficar travado enquanto o cliente é chamado
+	uploadMu sync.Mutex
+	uploads  map[uploadTorrent]*uploadState
+	done     chan struct{}
+}
+
+// uploadTorrent é o que o throttle usa de um torrent do cliente para controlar o envio
+type uploadTorrent interface {
+	InfoHash() metainfo.Hash
+	Stats() torrent.TorrentStats
+	AllowDataUpload()
+	DisallowDataUpload()
+}
+
+// uploadState é o controle do envio de um torrent
+type uploadState struct {
+	wanted  bool
+	held    bool
+	written int64
+}
+
+// newThrottle cria os limitadores das categorias configuradas
+func newThrottle(cfg *config.Config) *throttle {
+	th := &throttle{
+		categories: make(map[string]*limits),
+		torrents:   make(map[metainfo.Hash]*limits),
+		uploads:    make(map[uploadTorrent]*uploadState),
+		done:       make(chan struct{}),
+	}
+	for _, c := range cfg.Categories {
+		if c.DownloadRateLimit > 0 || c.UploadRateLimit > 0 {
+			th.categories[c.Name] = &limits{
+				download: newLimiter(c.DownloadRateLimit),
+				upload:   newLimiter(c.UploadRateLimit),
+			}
+		}
+	}
+	return th
+}
+
+// newLimiter cria um limitador de bytes por segundo; zero significa sem limite
+func newLimiter(bytesPerSecond int64) *rate.Limiter {
+	if bytesPerSecond <= 0 {
+		return nil
+	}
+	return rate.NewLimiter(rate.Limit(bytesPerSecond), max(int(bytesPerSecond), minBurst))
+}
+
+// assign aplica os limites da categoria ao torrent; nil remove os limites
+func (th *throttle) assign(ih metainfo.Hash, c *config.Category) {
+	th.mu.Lock()
+	defer th.mu.Unlock()
+
+	if c == nil || th.categories[c.Name] == nil {
+		delete(th.torrents, ih)
+		return
+	}
+	th.torrents[ih] = th.categories[c.Name]
+}
+
+// lookup retorna os limites de um torrent, ou nil se não houver
+func (th *throttle) lookup(ih metainfo.Hash) *limits {
+	th.mu.Lock()
+	defer th.mu.Unlock()
+	return th.torrents[ih]
+}
+
+// limitsUpload indica se alguma categoria tem limite de upload
+func (th *throttle) limitsUpload() bool {
+	for _, l := range th.categories {
+		if l.upload != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// allowUpload permite o envio do torrent, que só é retomado quando a categoria
+// estiver dentro do limite
+func (th *throttle) allowUpload(t uploadTorrent) {
+	th.uploadMu.Lock()
+	defer th.uploadMu.Unlock()
+
+	st := th.uploadState(t)
+	st.wanted = true
+	if !st.held {
+		t.AllowDataUpload()
+	}
+}
+
+// disallowUpload bloqueia o envio do torrent, com ou sem limite
+func (th *throttle) disallowUpload(t uploadTorrent) {
+	th.uploadMu.Lock()
+	defer th.uploadMu.Unlock()
+
+	th.uploadState(t).wanted = false
+	t.DisallowDataUpload()
+}
+
+// forget descarta o controle do envio de um torrent retirado do cliente
+func (th *throttle) forget(t uploadTorrent) {
+	th.uploadMu.Lock()
+	defer th.uploadMu.Unlock()
+	delete(th.uploads, t)
+}
+
+// uploadState retorna o controle do envio do torrent, criando-o com o que já
+// foi enviado; deve ser chamado com uploadMu travado
+func (th *throttle) uploadState(t uploadTorrent) *uploadState {
+	st := th.uploads[t]
+	if st == nil {
+		stats := t.Stats()
+		st = &uploadState{wanted: true, written: stats.BytesWrittenData.Int64()}
+		th.uploads[t] = st
+	}
+	return st
+}
+
+// run mede periodicamente o envio dos torrents do cliente até close ser chamado
+func (th *throttle) run(client *torrent.Client) {
+	ticker := time.NewTicker(uploadCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case now := <-ticker.C:
+			torrents := client.Torrents()
+			list := make([]uploadTorrent, len(torrents))
+			for i, t := range torrents {
+				list[i] = t
+			}
+			th.checkUploads(now, list)
+		case <-th.done:
+			return
+		}
+	}
+}
+
+// checkUploads desconta dos limitadores o que cada torrent enviou desde a
+// última medição, bloqueando o envio dos torrents das categorias que passaram
+// do limite e liberando os das que voltaram a ficar dentro dele
+func (th *throttle) checkUploads(now time.Time, torrents []uploadTorrent) {
+	th.uploadMu.Lock()
+	defer th.uploadMu.Unlock()
+
+	for _, t := range torrents {
+		st := th.uploadState(t)
+		stats := t.Stats()
+		written := stats.BytesWrittenData.Int64()
+		sent := written - st.written
+		st.written = written
+
+		l := th.lookup(t.InfoHash())
+		over := false
+		if l != nil && l.upload != nil {
+			reserveN(l.upload, now, int(sent))
+			// Uma reserva vazia só espera se o limitador está devendo
+			over = l.upload.ReserveN(now, 0).DelayFrom(now) > 0
+		}
+
+		switch {
+		case over && !st.held:
+			st.held = true
+			t.DisallowDataUpload()
+		case !over && st.held:
+			st.held = false
+			if st.wanted {
+				t.AllowDataUpload()
+			}
+		}
+	}
+}
+
+// close para a medição do envio
+func (th *throttle) close() {
+	close(th.done)
+}
+
+// wrap envolve um armazenamento para aplicar os limites de cada torrent
+func (th *throttle) wrap(impl storage.ClientImpl) storage.ClientImpl {
+	return throttledStorage{ClientImpl: impl, throttle: th}
+}
+
+// throttledStorage é um armazenamento cujas peças respeitam os limites do torrent
+type throttledStorage struct {
+	storage.ClientImpl
+	throttle *throttle
+}
+
+// OpenTorrent abre o torrent no armazenamento original e envolve as suas peças
+func (s throttledStorage) OpenTorrent(ctx context.Context, info *metainfo.Info, ih metainfo.Hash) (storage.TorrentImpl, error) {
+	impl, err := s.ClientImpl.OpenTorrent(ctx, info, ih)
+	if err != nil {
+		return impl, err
+	}
+
+	if piece := impl.Piece; piece != nil {
+		impl.Piece = func(p metainfo.Piece) storage.PieceImpl {
+			return s.piece(piece(p), p, ih)
+		}
+	}
+	if piece := impl.PieceWithHash; piece != nil {
+		impl.PieceWithHash = func(p metainfo.Piece, hash g.Option[[]byte]) storage.PieceImpl {
+			return s.piece(piece(p, hash), p, ih)
+		}
+	}
+	return impl, nil
+}
+
+// piece envolve uma peça do armazenamento original
+func (s throttledStorage) piece(impl storage.PieceImpl, p metainfo.Piece, ih metainfo.Hash) storage.PieceImpl {
+	return throttledPiece{
+		PieceImpl: impl,
+		length:    p.Length(),
+		throttle:  s.throttle,
+		infoHash:  ih,
+	}
+}
+
+// throttledPiece aguarda o limitador de download antes de escrever os dados recebidos
+type throttledPiece struct {
+	storage.PieceImpl
+	length   int64
+	throttle *throttle
+	infoHash metainfo.Hash
+}
+
+// WriteAt grava dados recebidos, respeitando o limite de download
+func (p throttledPiece) WriteAt(b []byte, off int64) (int, error) {
+	if l := p.throttle.lookup(p.infoHash); l != nil {
+		waitN(l.download, len(b))
+	}
+	return p.PieceImpl.WriteAt(b, off)
+}
+
+// WriteTo mantém a leitura otimizada do armazenamento original, usada na
+// verificação das peças
+func (p throttledPiece) WriteTo(w io.Writer) (int64, error) {
+	if wt, ok := p.PieceImpl.(io.WriterTo); ok {
+		return wt.WriteTo(w)
+	}
+	return io.CopyN(w, io.NewSectionReader(p.PieceImpl, 0, p.length), p.length)
+}
+
+// reserveN desconta n bytes já transferidos do limitador, que fica devendo
+// quando passam do que o balde comporta
+func reserveN(l *rate.Limiter, now time.Time, n int) {
+	for n > 0 {
+		k := min(n, l.Burst())
+		l.ReserveN(now, k)
+		n -= k
+	}
+}
+
+// waitN aguarda n bytes no limitador, em partes que caibam no balde
+func waitN(l *rate.Limiter, n int) {
+	if l == nil {
+		return
+	}
+	for n > 0 {
+		k := min(n, l.Burst())
+		l.WaitN(context.Background(), k)
+		n -= k
+	}
+}
@@ -0,0 +1,191 @@
+package downloader
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/alucod3/gorrent/internal/config"
+	"github.com/anacrolix/torrent"
+	"github.com/anacrolix/torrent/metainfo"
+)
+
+// fakeUpload é um torrent que envia enquanto o envio estiver permitido
+type fakeUpload struct {
+	ih      metainfo.Hash
+	written int64
+	allowed bool
+}
+
+func (f *fakeUpload) InfoHash() metainfo.Hash { return f.ih }
+func (f *fakeUpload) AllowDataUpload()        { f.allowed = true }
+func (f *fakeUpload) DisallowDataUpload()     { f.allowed = false }
+
+func (f *fakeUpload) Stats() torrent.TorrentStats {
+	var st torrent.TorrentStats
+	st.BytesWrittenData.Add(f.written)
+	return st
+}
+
+// send simula os peers pedindo dados a rate bytes por segundo durante d
+func (f *fakeUpload) send(rate int64, d time.Duration) {
+	if f.allowed {
+		f.written += int64(float64(rate) * d.Seconds())
+	}
+}
+
+func testThrottle() *throttle {
+	return newThrottle(&config.Config{Categories: []config.Category{
+		{Name: "lenta", UploadRateLimit: 100 << 10},
+		{Name: "rapida", UploadRateLimit: 10 << 20},
+		{Name: "livre"},
+	}})
+}
+
+func TestThrottleUploadConverges(t *testing.T) {
+	tests := []struct {
+		name  string
+		limit int64
+		peers int64
+	}{
+		{name: "peers quatro vezes mais rápidos", limit: 100 << 10, peers: 400 << 10},
+		{name: "peers vinte vezes mais rápidos", limit: 100 << 10, peers: 2 << 20},
+		{name: "limite maior que o balde mínimo", limit: 1 << 20, peers: 3 << 20},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			th := newThrottle(&config.Config{Categories: []config.Category{
+				{Name: "limitada", UploadRateLimit: tt.limit},
+			}})
+			f := &fakeUpload{ih: metainfo.Hash{1}, allowed: true}
+			th.assign(f.ih, &config.Category{Name: "limitada"})
+
+			const duration = 2 * time.Minute
+			now := time.Now()
+			for elapsed := time.Duration(0); elapsed < duration; elapsed += uploadCheckInterval {
+				f.send(tt.peers, uploadCheckInterval)
+				now = now.Add(uploadCheckInterval)
+				th.checkUploads(now, []uploadTorrent{f})
+			}
+
+			got := float64(f.written) / duration.Seconds()
+			if math.Abs(got-float64(tt.limit)) > float64(tt.limit)*0.1 {
+				t.Errorf("taxa de envio %.0f B/s, limite %d B/s", got, tt.limit)
+			}
+		})
+	}
+}
+
+// overLimit faz o torrent passar do limite da categoria lenta, até ser bloqueado
+func overLimit(t *testing.T, th *throttle, f *fakeUpload, now time.Time) time.Time {
+	t.Helper()
+	for i := 0; f.allowed; i++ {
+		if i > 100 {
+			t.Fatal("envio acima do limite não foi bloqueado")
+		}
+		f.send(10<<20, uploadCheckInterval)
+		now = now.Add(uploadCheckInterval)
+		th.checkUploads(now, []uploadTorrent{f})
+	}
+	return now
+}
+
+// idle deixa o tempo passar sem envio até o limitador se recuperar
+func idle(th *throttle, f *fakeUpload, now time.Time) {
+	for range 200 {
+		now = now.Add(uploadCheckInterval)
+		th.checkUploads(now, []uploadTorrent{f})
+	}
+}
+
+func TestThrottleUploadNotStuck(t *testing.T) {
+	tests := []struct {
+		name string
+		// whileHeld é o que acontece enquanto o envio está bloqueado pelo limite
+		whileHeld func(th *throttle, f *fakeUpload)
+		// afterRecover é o que acontece depois que o limitador se recupera
+		afterRecover func(th *throttle, f *fakeUpload)
+		allowed      bool
+	}{
+		{
+			name:    "sem mudanças",
+			allowed: true,
+		},
+		{
+			name: "pausado e retomado durante o bloqueio",
+			whileHeld: func(th *throttle, f *fakeUpload) {
+				th.disallowUpload(f)
+				th.allowUpload(f)
+			},
+			allowed: true,
+		},
+		{
+			name: "pausado durante o bloqueio",
+			whileHeld: func(th *throttle, f *fakeUpload) {
+				th.disallowUpload(f)
+			},
+			allowed: false,
+		},
+		{
+			name: "pausado durante o bloqueio e retomado depois",
+			whileHeld: func(th *throttle, f *fakeUpload) {
+				th.disallowUpload(f)
+			},
+			afterRecover: func(th *throttle, f *fakeUpload) {
+				th.allowUpload(f)
+			},
+			allowed: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			th := testThrottle()
+			f := &fakeUpload{ih: metainfo.Hash{1}, allowed: true}
+			th.assign(f.ih, &config.Category{Name: "lenta"})
+
+			now := overLimit(t, th, f, time.Now())
+			if tt.whileHeld != nil {
+				tt.whileHeld(th, f)
+				if f.allowed {
+					t.Fatal("envio liberado antes de o limitador se recuperar")
+				}
+			}
+			idle(th, f, now)
+			if tt.afterRecover != nil {
+				tt.afterRecover(th, f)
+			}
+			if f.allowed != tt.allowed {
+				t.Errorf("envio permitido %v, esperado %v", f.allowed, tt.allowed)
+			}
+		})
+	}
+}
+
+func TestThrottleUploadCategoryChange(t *testing.T) {
+	tests := []struct {
+		name     string
+		category *config.Category
+	}{
+		{name: "sem categoria", category: nil},
+		{name: "categoria sem limites", category: &config.Category{Name: "livre"}},
+		{name: "categoria com folga", category: &config.Category{Name: "rapida"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			th := testThrottle()
+			f := &fakeUpload{ih: metainfo.Hash{1}, allowed: true}
+			th.assign(f.ih, &config.Category{Name: "lenta"})
+			now := overLimit(t, th, f, time.Now())
+
+			// Basta a próxima medição para o envio voltar na nova categoria
+			th.assign(f.ih, tt.category)
+			th.checkUploads(now.Add(uploadCheckInterval), []uploadTorrent{f})
+			if !f.allowed {
+				t.Error("envio continua bloqueado depois da troca de categoria")
+			}
+		})
+	}
+}
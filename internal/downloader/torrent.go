@@ -2,86 +2,129 @@ package downloader
 
 import (
 	"context"
+	"errors"
 	"fmt"
-	"os"
 	"path/filepath"
 	"strconv"
-	"strings"
 	"time"
 
 	"github.com/alucod3/gorrent/internal/cli"
 	"github.com/alucod3/gorrent/internal/config"
+	"github.com/alucod3/gorrent/internal/history"
+	"github.com/alucod3/gorrent/internal/hooks"
 	"github.com/alucod3/gorrent/pkg/utils"
 	"github.com/anacrolix/torrent"
+	"github.com/anacrolix/torrent/types"
 )
 
 // TorrentDownloader gerencia o download de torrents
 type TorrentDownloader struct {
 	config   *config.Config
 	progress *cli.ProgressUI
-	client   *torrent.Client
+	hooks    *hooks.Runner
+	history  *history.Store
+	engine   *engine
+	category *config.Category
+	// existing é o diretório da cópia já baixada que está sendo usada, se houver
+	existing string
 }
 
-// New cria um novo gerenciador de downloads
-func New(cfg *config.Config, progress *cli.ProgressUI) *TorrentDownloader {
+// New cria um novo gerenciador de downloads; hooks pode ser nil
+func New(cfg *config.Config, progress *cli.ProgressUI, hooks *hooks.Runner) *TorrentDownloader {
 	return &TorrentDownloader{
 		config:   cfg,
 		progress: progress,
+		hooks:    hooks,
+		history:  history.New(cfg.HistoryPath()),
 	}
 }
 
 // Download inicia o download de um torrent
-func (d *TorrentDownloader) Download(ctx context.Context, link string) error {
+func (d *TorrentDownloader) Download(ctx context.Context, link string, opts Options) (err error) {
+	var t *torrent.Torrent
+	started := time.Now()
+
+	// Executar os hooks e registrar o download no histórico de acordo com o resultado
+	defer func() {
+		// Um torrent já baixado que foi pulado não chegou a ser baixado
+		if errors.Is(err, ErrDuplicate) {
+			return
+		}
+		p := d.hookPayload(t, link, err)
+		d.hooks.Run(p)
+		d.recordHistory(p, t, link, started)
+	}()
+
+	if err := opts.validate(d.config); err != nil {
+		return err
+	}
+
 	// Garantir que o diretório de download existe
 	if err := d.config.EnsureDownloadPath(); err != nil {
 		return fmt.Errorf("erro ao criar diretório de download: %w", err)
 	}
 
 	// Configurar o cliente torrent
-	config := torrent.NewDefaultClientConfig()
-	config.DataDir = d.config.DownloadPath
-	config.Seed = d.config.Seed
-
-	client, err := torrent.NewClient(config)
+	e, err := newEngine(d.config)
 	if err != nil {
-		return fmt.Errorf("erro ao criar cliente torrent: %w", err)
+		return err
 	}
-	defer client.Close()
+	defer e.Close()
 
-	d.client = client
-
-	var t *torrent.Torrent
+	d.engine = e
 
 	// Adicionar o torrent baseado no tipo de link
 	t, err = d.addTorrent(link)
 	if err != nil {
 		return err
 	}
+	if err := e.trackers.add(t, opts.Trackers); err != nil {
+		return err
+	}
+	t.AddWebSeeds(opts.WebSeeds)
 
 	// Obter metadados
 	if err := d.fetchMetadata(ctx, t); err != nil {
 		return err
 	}
 
+	// Com os metadados é possível escolher a categoria pelas regras
+	d.category = matchCategory(d.config, t, opts.Category)
+	e.throttle.assign(t.InfoHash(), d.category)
+
+	// Avisar se o torrent já foi baixado, seguindo a ação escolhida
+	existing, err := d.handleDuplicate(t, opts)
+	if err != nil {
+		return err
+	}
+	t = existing
+
+	// Os dados não poderiam ser movidos ao terminar, então o download nem começa
+	if d.existing == "" {
+		if path, ok := e.occupied(t, destinationDir(d.config, d.category)); ok {
+			return errOccupied(path)
+		}
+	}
+
+	// Definir quais arquivos baixar e em que ordem
+	if err := applyPriorities(t, opts.FilePriorities); err != nil {
+		return err
+	}
+	seq, err := newSequencer(t, opts, d.config.SequentialReadahead)
+	if err != nil {
+		return err
+	}
+
 	// Exibir informações
-	d.displayTorrentInfo(t)
+	d.displayTorrentInfo(t, seq)
 
 	// Iniciar o download
-	return d.startDownload(ctx, t)
+	return d.startDownload(ctx, t, seq)
 }
 
 // addTorrent adiciona um torrent baseado no tipo de entrada (arquivo local, magnet, etc)
 func (d *TorrentDownloader) addTorrent(link string) (*torrent.Torrent, error) {
-	if _, err := os.Stat(link); err == nil {
-		// É um arquivo local
-		return d.client.AddTorrentFromFile(link)
-	} else if strings.HasPrefix(link, "magnet:") {
-		// É um magnet link
-		return d.client.AddMagnet(link)
-	} else {
-		// URL não suportada
-		return nil, fmt.Errorf("URLs diretas de .torrent não são suportadas nesta versão. Por favor, use um magnet link ou arquivo .torrent local")
-	}
+	return d.engine.add(link)
 }
 
 // fetchMetadata obtém os metadados do torrent
@@ -118,24 +161,39 @@ func (d *TorrentDownloader) fetchMetadata(ctx context.Context, t *torrent.Torren
 }
 
 // displayTorrentInfo exibe informações sobre o torrent
-func (d *TorrentDownloader) displayTorrentInfo(t *torrent.Torrent) {
+func (d *TorrentDownloader) displayTorrentInfo(t *torrent.Torrent, seq *sequencer) {
+	var sequential string
+	if seq != nil {
+		sequential = seq.path()
+	}
+
+	// O resultado do mapeamento de porta explica por que poucos peers se conectam
+	var portForwarding string
+	if pf := d.engine.portfwd; pf != nil {
+		pf.wait(portMappingWait)
+		portForwarding = describePortMappings(pf.status())
+	}
+
 	// Criar um serviço de UI aqui e usá-lo para exibir as informações
 	ui := cli.NewUI()
 	ui.DisplayTorrentInfo(
 		t.Name(),
 		utils.BytesToString(t.Length()),
 		strconv.Itoa(len(t.Files())),
-		filepath.Join(d.config.DownloadPath, t.Name()),
+		filepath.Join(d.saveDir(), t.Name()),
+		categoryName(d.category),
+		sequential,
+		d.config.Encryption,
+		portForwarding,
 	)
 }
 
 // startDownload inicia o download do torrent
-func (d *TorrentDownloader) startDownload(ctx context.Context, t *torrent.Torrent) error {
-	// Iniciar o download
-	t.DownloadAll()
+func (d *TorrentDownloader) startDownload(ctx context.Context, t *torrent.Torrent, seq *sequencer) error {
+	_, total := wantedProgress(t)
 
 	// Criar barra de progresso
-	d.progress.CreateDownloadBar(t.Length(), "Baixando")
+	d.progress.CreateDownloadBar(total, "Baixando")
 
 	// Monitorar o progresso
 	ticker := time.NewTicker(d.config.ProgressCheckInterval)
@@ -145,19 +203,37 @@ func (d *TorrentDownloader) startDownload(ctx context.Context, t *torrent.Torren
 		select {
 		case <-ticker.C:
 			stats := t.Stats()
-			bytesCompleted := t.BytesCompleted()
+			bytesCompleted, _ := wantedProgress(t)
+
+			// Avançar a janela do download em ordem
+			seq.update()
 
 			// Atualizar a barra de progresso
 			d.progress.UpdateDownloadProgress(bytesCompleted)
 
-			// Exibir estatísticas
-			d.progress.DisplayDownloadStats(bytesCompleted, stats.ActivePeers, t.Length())
+			// Acompanhar os arquivos de torrents com vários arquivos
+			if len(t.Files()) > 1 {
+				d.progress.UpdateFileProgress(fileProgress(t))
+			}
+
+			// Exibir estatísticas, com a parte dos web seeds separada
+			d.progress.UpdateWebSeedProgress(d.engine.webseeds.read(t))
+			d.progress.DisplayDownloadStats(bytesCompleted, stats.ActivePeers, total)
 
 			// Verificar se o download está completo
-			if bytesCompleted == t.Length() {
+			if bytesCompleted == total {
 				d.progress.CompleteDownloadBar()
 				fmt.Println()
-				return nil
+				d.progress.DisplayDownloadSummary(stats.BytesWrittenData.Int64())
+
+				// A cópia existente já está no lugar e pode continuar semeando
+				if d.existing != "" {
+					return d.seedExisting(ctx)
+				}
+
+				// O processo termina em seguida, então não há por que continuar semeando
+				_, err := d.engine.moveCompleted(t, destinationDir(d.config, d.category), false)
+				return err
 			}
 
 		case <-ctx.Done():
@@ -165,3 +241,134 @@ func (d *TorrentDownloader) startDownload(ctx context.Context, t *torrent.Torren
 		}
 	}
 }
+
+// handleDuplicate avisa se o torrent já foi baixado e segue a ação configurada
+// ou escolhida pelo usuário, retornando o torrent que deve ser baixado
+func (d *TorrentDownloader) handleDuplicate(t *torrent.Torrent, opts Options) (*torrent.Torrent, error) {
+	action, err := duplicateAction(d.config, opts.Duplicate)
+	if err != nil || action == DuplicateDownload {
+		return t, err
+	}
+	dup, found := findDuplicate(d.config, d.history, t, d.category)
+	if !found {
+		return t, nil
+	}
+
+	ui := cli.NewUI()
+	ui.ShowWarning(fmt.Sprintf("%s já foi baixado: %s", t.Name(), dup))
+	if action == DuplicateAsk && !ui.Interactive() {
+		// Sem terminal não há como perguntar, então segue como o daemon:
+		// semeia a cópia existente ou baixa de novo
+		action = DuplicateDownload
+		if dup.path != "" {
+			action = DuplicateSeed
+			ui.ShowInfo("Sem terminal para perguntar, semeando a cópia existente")
+		} else {
+			ui.ShowInfo("Sem terminal para perguntar, baixando de novo")
+		}
+	}
+	if action == DuplicateAsk {
+		actions := []string{DuplicateSkip}
+		choices := []string{"pular"}
+		// Com a cópia no destino, o novo download não teria para onde ser movido
+		if _, ok := d.engine.occupied(t, destinationDir(d.config, d.category)); !ok {
+			actions = append([]string{DuplicateDownload}, actions...)
+			choices = append([]string{"baixar de novo"}, choices...)
+		}
+		if dup.path != "" {
+			actions = append([]string{DuplicateSeed}, actions...)
+			choices = append([]string{"verificar e semear a cópia existente"}, choices...)
+		}
+		i, err := ui.ReadChoice("O que fazer?", choices...)
+		if err != nil {
+			return t, err
+		}
+		action = actions[i]
+	}
+
+	switch {
+	case action == DuplicateSkip:
+		return t, fmt.Errorf("%w: %s", ErrDuplicate, t.Name())
+	case action == DuplicateSeed && dup.path != "":
+		existing, err := d.engine.useExisting(t, dup.dir())
+		if err != nil {
+			return t, err
+		}
+		if existing != t {
+			d.existing = dup.dir()
+		}
+		return existing, nil
+	}
+	// Sem cópia para semear, o torrent é baixado de novo
+	return t, nil
+}
+
+// saveDir retorna onde os dados ficam ao terminar: a cópia existente, se
+// estiver sendo usada, ou o destino da categoria
+func (d *TorrentDownloader) saveDir() string {
+	if d.existing != "" {
+		return d.existing
+	}
+	return destinationDir(d.config, d.category)
+}
+
+// seedExisting semeia a cópia existente até o usuário interromper, se a
+// política de semeadura permitir
+func (d *TorrentDownloader) seedExisting(ctx context.Context) error {
+	if !shouldSeed(d.config, d.category) {
+		return nil
+	}
+	cli.NewUI().ShowInfo("Cópia verificada, semeando até ser interrompido (Ctrl+C)")
+	<-ctx.Done()
+	return nil
+}
+
+// fileProgress resume o progresso de cada arquivo para a interface
+func fileProgress(t *torrent.Torrent) []cli.FileProgress {
+	files := t.Files()
+	progress := make([]cli.FileProgress, len(files))
+	for i, f := range files {
+		progress[i] = cli.FileProgress{
+			Path:           f.DisplayPath(),
+			Size:           f.Length(),
+			BytesCompleted: f.BytesCompleted(),
+			Skipped:        f.Priority() == types.PiecePriorityNone,
+		}
+	}
+	return progress
+}
+
+// recordHistory registra o download no histórico. Uma falha ao gravá-lo não
+// muda o resultado do download.
+func (d *TorrentDownloader) recordHistory(p hooks.Payload, t *torrent.Torrent, link string, started time.Time) {
+	var downloaded int64
+	if t != nil {
+		stats := t.Stats()
+		downloaded = stats.BytesReadUsefulData.Int64()
+	}
+	d.history.Add(historyEntry(p, t, link, started, downloaded))
+}
+
+// hookPayload descreve o resultado do download para os hooks
+func (d *TorrentDownloader) hookPayload(t *torrent.Torrent, link string, err error) hooks.Payload {
+	var p hooks.Payload
+	if t != nil && err == nil {
+		p = payloadFor(t, d.saveDir())
+	} else if t != nil {
+		p = payloadFor(t, d.config.IncompleteDir())
+	} else {
+		p = hooks.Payload{Name: link}
+	}
+	p.Category = categoryName(d.category)
+
+	switch {
+	case err == nil:
+		p.Event = hooks.EventComplete
+	case errors.Is(err, context.Canceled):
+		p.Event = hooks.EventCancel
+	default:
+		p.Event = hooks.EventFailure
+		p.Error = err.Error()
+	}
+	return p
+}
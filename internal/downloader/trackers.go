@@ -0,0 +1,691 @@
+package downloader
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"math/rand/v2"
+	"net"
+	"net/url"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/anacrolix/dht/v2/krpc"
+	"github.com/anacrolix/log"
+	"github.com/anacrolix/torrent"
+	"github.com/anacrolix/torrent/metainfo"
+	"github.com/anacrolix/torrent/tracker"
+)
+
+// Estados de um tracker
+const (
+	TrackerWaiting    = "waiting"
+	TrackerAnnouncing = "announcing"
+	TrackerWorking    = "working"
+	TrackerError      = "error"
+	// TrackerWebTorrent é um tracker WebTorrent, anunciado pelo próprio
+	// cliente, que não informa o resultado dos anúncios
+	TrackerWebTorrent = "webtorrent"
+)
+
+// Intervalos de anúncio usados quando o tracker não informa um ou falha
+const (
+	defaultAnnounceInterval = 30 * time.Minute
+	minAnnounceInterval     = time.Minute
+	retryAnnounceInterval   = 5 * time.Minute
+	stoppedAnnounceTimeout  = 3 * time.Second
+)
+
+// TrackerStatus descreve o resultado dos anúncios a um tracker
+type TrackerStatus struct {
+	URL          string    `json:"url"`
+	Tier         int       `json:"tier"`
+	Status       string    `json:"status"`
+	LastError    string    `json:"last_error,omitempty"`
+	Seeders      int       `json:"seeders"`
+	Leechers     int       `json:"leechers"`
+	Peers        int       `json:"peers"`
+	LastAnnounce time.Time `json:"last_announce"`
+	NextAnnounce time.Time `json:"next_announce"`
+}
+
+// errPrivateTrackers impede que trackers de fora do .torrent sejam usados em um torrent privado
+var errPrivateTrackers = errors.New("torrent privado: só os trackers do próprio .torrent podem ser usados")
+
+// validateTrackers verifica se as URLs são de trackers HTTP(S), UDP ou WebTorrent
+func validateTrackers(urls []string) error {
+	for _, raw := range urls {
+		u, err := url.Parse(raw)
+		if err != nil || u.Host == "" {
+			return fmt.Errorf("tracker inválido: %s", raw)
+		}
+		switch u.Scheme {
+		case "http", "https", "udp", "udp4", "udp6", "ws", "wss":
+		default:
+			return fmt.Errorf("tracker inválido %s: use http, https, udp ou ws", raw)
+		}
+	}
+	return nil
+}
+
+// isWebTracker indica se a URL é de um tracker WebTorrent
+func isWebTracker(raw string) bool {
+	u, err := url.Parse(raw)
+	return err == nil && (u.Scheme == "ws" || u.Scheme == "wss")
+}
+
+// errAnnouncedByTrackerSet faz o cliente desistir dos anúncios HTTP e UDP
+var errAnnouncedByTrackerSet = errors.New("anúncio feito pelo trackerSet")
+
+// skipTrackerLookup substitui a resolução dos trackers HTTP e UDP no cliente,
+// que então só anuncia aos trackers WebTorrent. DisableTrackers desligaria
+// também esses, então o cliente continua tentando os demais a cada minuto e
+// desiste antes de abrir qualquer conexão. Isso depende de o cliente resolver
+// o endereço antes de anunciar, o que TestClientSkipsTrackerAnnounces confere.
+func skipTrackerLookup(*url.URL) ([]net.IP, error) {
+	return nil, errAnnouncedByTrackerSet
+}
+
+// trackerSet anuncia os torrents de um cliente aos seus trackers HTTP e UDP. O
+// cliente sabe anunciar sozinho, mas não expõe o resultado dos anúncios, então
+// ele só anuncia aos trackers WebTorrent, que dependem das suas conexões
+// WebRTC, e os demais anúncios são feitos aqui.
+type trackerSet struct {
+	client *torrent.Client
+	config *torrent.ClientConfig
+	// proxyPeers indica que o tráfego com os peers passa pelo proxy; só então
+	// trackers UDP, que não passam por ele, são recusados
+	proxyPeers bool
+	udpDirect  sync.Once
+	key        int32
+	mu         sync.Mutex
+	announcers map[metainfo.Hash]*announcer
+}
+
+func newTrackerSet(client *torrent.Client, cfg *torrent.ClientConfig, proxyPeers bool) *trackerSet {
+	return &trackerSet{
+		client:     client,
+		config:     cfg,
+		proxyPeers: proxyPeers,
+		key:        rand.Int32(),
+		announcers: make(map[metainfo.Hash]*announcer),
+	}
+}
+
+// start passa a anunciar um torrent aos trackers do seu metainfo
+func (ts *trackerSet) start(t *torrent.Torrent) {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	ih := t.InfoHash()
+	if _, ok := ts.announcers[ih]; ok {
+		return
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	a := &announcer{set: ts, infoHash: ih, wake: make(chan struct{}, 1), ctx: ctx, cancel: cancel}
+	ts.announcers[ih] = a
+	a.add(announceList(t), nil)
+	a.wg.Add(2)
+	go a.run()
+	go a.watchPrivate(t)
+}
+
+// add inclui trackers em um torrent, cada um em um novo nível. Torrents
+// privados os recusam; em magnets, isso só é sabido quando chega o metainfo, e
+// então eles deixam de ser anunciados.
+func (ts *trackerSet) add(t *torrent.Torrent, urls []string) error {
+	if len(urls) == 0 {
+		return nil
+	}
+	if isPrivate(t) {
+		return errPrivateTrackers
+	}
+	if err := validateTrackers(urls); err != nil {
+		return err
+	}
+
+	// O metainfo do torrent também guarda os trackers, para quando ele for readicionado
+	tiers := make([][]string, len(announceList(t))+len(urls))
+	for i, u := range urls {
+		tiers[len(tiers)-len(urls)+i] = []string{u}
+	}
+	t.AddTrackers(tiers)
+
+	ts.mu.Lock()
+	a := ts.announcers[t.InfoHash()]
+	ts.mu.Unlock()
+	if a != nil {
+		a.add(announceList(t), urls)
+	}
+	return nil
+}
+
+// list retorna o estado dos trackers de um torrent
+func (ts *trackerSet) list(ih metainfo.Hash) []TrackerStatus {
+	ts.mu.Lock()
+	a := ts.announcers[ih]
+	ts.mu.Unlock()
+
+	list := make([]TrackerStatus, 0)
+	if a == nil {
+		return list
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for _, tier := range a.tiers {
+		for _, tr := range tier {
+			list = append(list, tr.status)
+		}
+	}
+	return list
+}
+
+// extra retorna os trackers que não vieram do metainfo nem do magnet de um
+// torrent, inclusive os que deixaram de ser anunciados por ele ser privado
+func (ts *trackerSet) extra(ih metainfo.Hash) map[string]bool {
+	ts.mu.Lock()
+	a := ts.announcers[ih]
+	ts.mu.Unlock()
+
+	extra := make(map[string]bool)
+	if a == nil {
+		return extra
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for u := range a.dropped {
+		extra[u] = true
+	}
+	for _, tier := range a.tiers {
+		for _, tr := range tier {
+			if tr.extra {
+				extra[tr.status.URL] = true
+			}
+		}
+	}
+	return extra
+}
+
+// stop para de anunciar um torrent, avisando os trackers em segundo plano
+func (ts *trackerSet) stop(ih metainfo.Hash) {
+	ts.mu.Lock()
+	a := ts.announcers[ih]
+	delete(ts.announcers, ih)
+	ts.mu.Unlock()
+
+	if a != nil {
+		go a.stop()
+	}
+}
+
+// close para todos os anúncios e aguarda os avisos de parada aos trackers
+func (ts *trackerSet) close() {
+	ts.mu.Lock()
+	announcers := ts.announcers
+	ts.announcers = make(map[metainfo.Hash]*announcer)
+	ts.mu.Unlock()
+
+	var wg sync.WaitGroup
+	for _, a := range announcers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			a.stop()
+		}()
+	}
+	wg.Wait()
+}
+
+// announcer anuncia um torrent aos seus trackers HTTP e UDP. Como pede a BEP
+// 12, cada anúncio vai a um só tracker: os níveis são percorridos em ordem, e
+// dentro de cada nível os trackers são tentados até um responder.
+type announcer struct {
+	set      *trackerSet
+	infoHash metainfo.Hash
+	mu       sync.Mutex
+	// tiers são os trackers de cada nível, na ordem em que são tentados
+	tiers [][]*trackerState
+	// current é o tracker que respondeu ao último anúncio
+	current *trackerState
+	// dropped são os trackers extras tirados de um torrent privado
+	dropped map[string]bool
+	// wake avisa o laço de anúncios que trackers foram acrescentados
+	wake   chan struct{}
+	last   tracker.AnnounceRequest
+	lastV2 *metainfo.Hash
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// trackerState é o estado de um tracker; o status é protegido pelo mutex do announcer
+type trackerState struct {
+	status    TrackerStatus
+	started   bool
+	completed bool
+	// extra indica um tracker que não veio do metainfo nem do magnet
+	extra bool
+	// web indica um tracker WebTorrent, anunciado pelo próprio cliente
+	web    bool
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+// add inclui os trackers que ainda não são conhecidos; os que estão em extra
+// foram acrescentados pelo usuário ou pela configuração
+func (a *announcer) add(tiers [][]string, extra []string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	known := make(map[string]bool)
+	for _, trackers := range a.tiers {
+		for _, tr := range trackers {
+			known[tr.status.URL] = true
+		}
+	}
+
+	added := false
+	for tier, urls := range tiers {
+		var list []*trackerState
+		for _, u := range urls {
+			if known[u] || validateTrackers([]string{u}) != nil {
+				continue
+			}
+			known[u] = true
+
+			tr := &trackerState{
+				status: TrackerStatus{URL: u, Tier: tier, Status: TrackerWaiting},
+				extra:  slices.Contains(extra, u),
+				web:    isWebTracker(u),
+			}
+			if tr.web {
+				tr.status.Status = TrackerWebTorrent
+				if a.set.config.DisableWebtorrent {
+					tr.status.Status = TrackerError
+					tr.status.LastError = "trackers WebTorrent não passam pelo proxy"
+				}
+			}
+			tr.ctx, tr.cancel = context.WithCancel(a.ctx)
+			list = append(list, tr)
+		}
+
+		// A ordem dentro de um nível é sorteada uma vez, ao conhecer os trackers
+		rand.Shuffle(len(list), func(i, j int) {
+			list[i], list[j] = list[j], list[i]
+		})
+		for len(a.tiers) <= tier {
+			a.tiers = append(a.tiers, nil)
+		}
+		a.tiers[tier] = append(a.tiers[tier], list...)
+		added = added || len(list) > 0
+	}
+
+	if added {
+		select {
+		case a.wake <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// run anuncia periodicamente até o announcer ser parado. Trackers
+// acrescentados só são tentados antes da hora se nenhum está respondendo.
+func (a *announcer) run() {
+	defer a.wg.Done()
+
+	for {
+		next := a.announceTiers()
+
+	wait:
+		for {
+			select {
+			case <-time.After(time.Until(next)):
+				break wait
+			case <-a.wake:
+				a.mu.Lock()
+				working := a.current != nil
+				a.mu.Unlock()
+				if !working {
+					break wait
+				}
+			case <-a.ctx.Done():
+				return
+			}
+		}
+	}
+}
+
+// announceTiers percorre os níveis em ordem até um tracker responder, que
+// passa a ser o primeiro do seu nível, e retorna quando deve ser feito o
+// próximo anúncio
+func (a *announcer) announceTiers() time.Time {
+	var failed []*trackerState
+	next := time.Now().Add(retryAnnounceInterval)
+	var current *trackerState
+
+tiers:
+	for tier := 0; ; tier++ {
+		a.mu.Lock()
+		if tier >= len(a.tiers) {
+			a.mu.Unlock()
+			break
+		}
+		trackers := slices.Clone(a.tiers[tier])
+		a.mu.Unlock()
+
+		for _, tr := range trackers {
+			if tr.web || tr.ctx.Err() != nil {
+				continue
+			}
+			at, err := a.announce(tr.ctx, tr, tracker.None)
+			if a.ctx.Err() != nil {
+				return time.Now()
+			}
+			switch {
+			case errors.Is(err, errNotInClient):
+				return at
+			case err != nil:
+				failed = append(failed, tr)
+			default:
+				next, current = at, tr
+				break tiers
+			}
+		}
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if current != nil {
+		tier := a.tiers[current.status.Tier]
+		if i := slices.Index(tier, current); i > 0 {
+			copy(tier[1:i+1], tier[:i])
+			tier[0] = current
+		}
+	}
+	// Os trackers que falharam voltam a ser tentados no próximo anúncio, e o
+	// que respondia antes deixa de ser usado se um nível anterior voltou
+	for _, tr := range failed {
+		tr.status.NextAnnounce = next
+	}
+	if a.current != nil && a.current != current && a.current.status.Status == TrackerWorking {
+		a.current.status.Status = TrackerWaiting
+		a.current.status.NextAnnounce = time.Time{}
+	}
+	a.current = current
+	return next
+}
+
+// watchPrivate para de anunciar aos trackers extras se o metainfo, ao chegar,
+// revelar que o torrent é privado. Eles também são tirados do cliente, que
+// anuncia aos trackers WebTorrent e guarda o metainfo.
+func (a *announcer) watchPrivate(t *torrent.Torrent) {
+	defer a.wg.Done()
+
+	select {
+	case <-t.GotInfo():
+	case <-a.ctx.Done():
+		return
+	}
+	if !isPrivate(t) {
+		return
+	}
+
+	a.mu.Lock()
+	a.dropped = make(map[string]bool)
+	for i, tier := range a.tiers {
+		a.tiers[i] = slices.DeleteFunc(tier, func(tr *trackerState) bool {
+			if tr.extra {
+				tr.cancel()
+				a.dropped[tr.status.URL] = true
+			}
+			return tr.extra
+		})
+	}
+	if a.current != nil && a.current.extra {
+		a.current = nil
+		select {
+		case a.wake <- struct{}{}:
+		default:
+		}
+	}
+	dropped := maps.Clone(a.dropped)
+	a.mu.Unlock()
+
+	if len(dropped) > 0 {
+		t.ModifyTrackers(withoutTrackers(announceList(t), dropped))
+	}
+}
+
+// stop interrompe os anúncios e avisa os trackers que já receberam o torrent
+func (a *announcer) stop() {
+	a.cancel()
+	a.wg.Wait()
+
+	ctx, cancel := context.WithTimeout(context.Background(), stoppedAnnounceTimeout)
+	defer cancel()
+
+	a.mu.Lock()
+	var trackers []*trackerState
+	for _, tier := range a.tiers {
+		for _, tr := range tier {
+			if tr.started {
+				trackers = append(trackers, tr)
+			}
+		}
+	}
+	a.mu.Unlock()
+
+	var wg sync.WaitGroup
+	for _, tr := range trackers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			a.announce(ctx, tr, tracker.Stopped)
+		}()
+	}
+	wg.Wait()
+}
+
+// errNotInClient indica que o torrent não está no cliente, por estar sendo
+// readicionado em outro local
+var errNotInClient = errors.New("torrent fora do cliente")
+
+// announce faz um anúncio e retorna quando deve ser feito o próximo. O evento
+// None é trocado por Started ou Completed quando é a hora de enviá-los.
+func (a *announcer) announce(ctx context.Context, tr *trackerState, event tracker.AnnounceEvent) (time.Time, error) {
+	t, ok := a.set.client.Torrent(a.infoHash)
+
+	a.mu.Lock()
+	var req tracker.AnnounceRequest
+	switch {
+	case ok:
+		req = a.request(t)
+		a.last = req
+		a.lastV2 = hybridInfoHash(t)
+	case event == tracker.Stopped && a.last.PeerId != [20]byte{}:
+		// O torrent já foi removido do cliente; vale o último progresso anunciado
+		req = a.last
+	default:
+		a.mu.Unlock()
+		return time.Now().Add(minAnnounceInterval), errNotInClient
+	}
+	if event == tracker.None {
+		switch {
+		case !tr.started:
+			event = tracker.Started
+		case !tr.completed && req.Left == 0:
+			event = tracker.Completed
+		}
+	}
+	tr.status.Status = TrackerAnnouncing
+	u := tr.status.URL
+	v2 := a.lastV2
+	a.mu.Unlock()
+
+	req.Event = event
+	res, err := a.do(ctx, u, req)
+	if err == nil && v2 != nil {
+		// Torrents híbridos também fazem parte do enxame v2
+		req.InfoHash = *v2
+		if v2res, err := a.do(ctx, u, req); err == nil {
+			res.Peers = append(res.Peers, v2res.Peers...)
+		}
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if event != tracker.Stopped && tr.ctx.Err() != nil {
+		// Anúncio interrompido pela parada do torrent ou do tracker
+		return time.Now(), tr.ctx.Err()
+	}
+
+	now := time.Now()
+	tr.status.LastAnnounce = now
+	if err != nil {
+		tr.status.Status = TrackerError
+		tr.status.LastError = err.Error()
+		tr.status.NextAnnounce = now.Add(retryAnnounceInterval)
+		return tr.status.NextAnnounce, err
+	}
+
+	switch event {
+	case tracker.Started:
+		tr.started = true
+		tr.completed = req.Left == 0
+	case tracker.Completed:
+		tr.completed = true
+	}
+
+	if ok && event != tracker.Stopped {
+		t.AddPeers(trackerPeers(res.Peers))
+	}
+
+	interval := time.Duration(res.Interval) * time.Second
+	if interval <= 0 {
+		interval = defaultAnnounceInterval
+	}
+	tr.status.Status = TrackerWorking
+	tr.status.LastError = ""
+	tr.status.Seeders = int(res.Seeders)
+	tr.status.Leechers = int(res.Leechers)
+	tr.status.Peers = len(res.Peers)
+	tr.status.NextAnnounce = now.Add(max(interval, minAnnounceInterval))
+	return tr.status.NextAnnounce, nil
+}
+
+// request monta o anúncio com o progresso atual do torrent
+func (a *announcer) request(t *torrent.Torrent) tracker.AnnounceRequest {
+	stats := t.Stats()
+	left := int64(-1)
+	if t.Info() != nil {
+		left = t.BytesMissing()
+	}
+
+	numWant := int32(200)
+	if left == 0 {
+		numWant = 50
+	}
+
+	return tracker.AnnounceRequest{
+		InfoHash:   t.InfoHash(),
+		PeerId:     a.set.client.PeerID(),
+		Port:       uint16(a.set.client.LocalPort()),
+		Key:        a.set.key,
+		NumWant:    numWant,
+		Left:       left,
+		Uploaded:   stats.BytesWrittenData.Int64(),
+		Downloaded: stats.BytesReadUsefulData.Int64(),
+	}
+}
+
+// hybridInfoHash retorna o info hash v2 truncado de um torrent híbrido, com o
+// qual ele também é anunciado; nos demais torrents, retorna nil
+func hybridInfoHash(t *torrent.Torrent) *metainfo.Hash {
+	if info := t.Info(); info == nil || !info.HasV1() {
+		return nil
+	}
+	v2, ok := infoHashV2(t)
+	if !ok {
+		return nil
+	}
+	return v2.ToShort()
+}
+
+// do envia o anúncio usando as mesmas opções de rede do cliente
+func (a *announcer) do(ctx context.Context, rawURL string, req tracker.AnnounceRequest) (tracker.AnnounceResponse, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return tracker.AnnounceResponse{}, err
+	}
+	cfg := a.set.config
+	if cfg.HTTPProxy != nil && u.Scheme != "http" && u.Scheme != "https" {
+		// Com os peers pelo proxy, anunciar diretamente revelaria o endereço
+		// que ele esconde; sem isso, os peers já veem o endereço de qualquer forma
+		if a.set.proxyPeers {
+			return tracker.AnnounceResponse{}, fmt.Errorf("trackers UDP não passam pelo proxy")
+		}
+		a.set.udpDirect.Do(func() {
+			cfg.Logger.Levelf(log.Warning, "trackers UDP não passam pelo proxy e são anunciados diretamente")
+		})
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, tracker.DefaultTrackerAnnounceTimeout)
+	defer cancel()
+
+	return tracker.Announce{
+		Context:             ctx,
+		TrackerUrl:          rawURL,
+		Request:             req,
+		HttpProxy:           cfg.HTTPProxy,
+		HttpRequestDirector: cfg.HttpRequestDirector,
+		DialContext:         cfg.TrackerDialContext,
+		ListenPacket:        cfg.TrackerListenPacket,
+		UserAgent:           cfg.HTTPUserAgent,
+		HostHeader:          u.Host,
+		ServerName:          u.Hostname(),
+		UdpNetwork:          u.Scheme,
+		ClientIp4:           krpc.NodeAddr{IP: cfg.PublicIp4},
+		ClientIp6:           krpc.NodeAddr{IP: cfg.PublicIp6},
+		Logger:              cfg.Logger,
+	}.Do()
+}
+
+// announceList retorna os trackers do torrent agrupados por nível
+func announceList(t *torrent.Torrent) [][]string {
+	mi := t.Metainfo()
+	return mi.UpvertedAnnounceList()
+}
+
+// withoutTrackers retorna os níveis sem os trackers indicados
+func withoutTrackers(tiers [][]string, drop map[string]bool) [][]string {
+	var list [][]string
+	for _, tier := range tiers {
+		tier = slices.DeleteFunc(slices.Clone(tier), func(u string) bool {
+			return drop[u]
+		})
+		if len(tier) > 0 {
+			list = append(list, tier)
+		}
+	}
+	return list
+}
+
+// trackerPeers converte os peers recebidos de um tracker
+func trackerPeers(peers []tracker.Peer) []torrent.PeerInfo {
+	infos := make([]torrent.PeerInfo, 0, len(peers))
+	for _, p := range peers {
+		info := torrent.PeerInfo{
+			Addr:   &net.TCPAddr{IP: p.IP, Port: p.Port},
+			Source: torrent.PeerSourceTracker,
+		}
+		copy(info.Id[:], p.ID)
+		infos = append(infos, info)
+	}
+	return infos
+}
@@ -0,0 +1,332 @@
+package downloader
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"slices"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/anacrolix/log"
+	"github.com/anacrolix/torrent"
+	"github.com/anacrolix/torrent/metainfo"
+	"github.com/anacrolix/torrent/tracker"
+)
+
+// fakeTracker é um tracker HTTP de teste que conta os anúncios recebidos
+type fakeTracker struct {
+	url      string
+	hits     atomic.Int32
+	fail     atomic.Bool
+	interval atomic.Int32
+}
+
+func startFakeTracker(t *testing.T) *fakeTracker {
+	t.Helper()
+	ft := &fakeTracker{}
+	ft.interval.Store(1800)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ft.hits.Add(1)
+		if ft.fail.Load() {
+			io.WriteString(w, "d14:failure reason4:fulae")
+			return
+		}
+		fmt.Fprintf(w, "d8:completei1e10:incompletei2e8:intervali%de5:peers0:e", ft.interval.Load())
+	}))
+	t.Cleanup(srv.Close)
+	ft.url = srv.URL + "/announce"
+	return ft
+}
+
+// testAnnouncer monta um announcer para um torrent de um cliente de teste,
+// com os níveis na ordem informada em vez de sorteada
+func testAnnouncer(t *testing.T, tiers [][]string) *announcer {
+	t.Helper()
+	cfg := torrent.TestingConfig(t)
+	cl, err := torrent.NewClient(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { cl.Close() })
+	ih := metainfo.Hash{1}
+	cl.AddTorrentInfoHash(ih)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	a := &announcer{
+		set:      newTrackerSet(cl, cfg, false),
+		infoHash: ih,
+		wake:     make(chan struct{}, 1),
+		ctx:      ctx,
+		cancel:   cancel,
+	}
+	a.add(tiers, nil)
+	for i, tier := range a.tiers {
+		slices.SortFunc(tier, func(x, y *trackerState) int {
+			return slices.Index(tiers[i], x.status.URL) - slices.Index(tiers[i], y.status.URL)
+		})
+	}
+	return a
+}
+
+// tierURLs retorna a ordem atual dos trackers de cada nível
+func tierURLs(a *announcer) [][]string {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	var list [][]string
+	for _, tier := range a.tiers {
+		var urls []string
+		for _, tr := range tier {
+			urls = append(urls, tr.status.URL)
+		}
+		list = append(list, urls)
+	}
+	return list
+}
+
+func TestAnnounceTiers(t *testing.T) {
+	tests := []struct {
+		name    string
+		tiers   [][]string
+		fail    []string
+		current string
+		order   [][]string
+		hits    map[string]int32
+	}{
+		{
+			name:    "primeiro responde",
+			tiers:   [][]string{{"a", "b"}, {"c"}},
+			current: "a",
+			order:   [][]string{{"a", "b"}, {"c"}},
+			hits:    map[string]int32{"a": 1},
+		},
+		{
+			name:    "promove o que responde no nível",
+			tiers:   [][]string{{"a", "b", "c"}},
+			fail:    []string{"a", "b"},
+			current: "c",
+			order:   [][]string{{"c", "a", "b"}},
+			hits:    map[string]int32{"a": 1, "b": 1, "c": 1},
+		},
+		{
+			name:    "passa ao próximo nível",
+			tiers:   [][]string{{"a", "b"}, {"c", "d"}},
+			fail:    []string{"a", "b"},
+			current: "c",
+			order:   [][]string{{"a", "b"}, {"c", "d"}},
+			hits:    map[string]int32{"a": 1, "b": 1, "c": 1},
+		},
+		{
+			name:  "nenhum responde",
+			tiers: [][]string{{"a"}, {"b"}},
+			fail:  []string{"a", "b"},
+			order: [][]string{{"a"}, {"b"}},
+			hits:  map[string]int32{"a": 1, "b": 1},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			trackers := make(map[string]*fakeTracker)
+			names := make(map[string]string)
+			var tiers [][]string
+			for _, tier := range tt.tiers {
+				var urls []string
+				for _, name := range tier {
+					ft := startFakeTracker(t)
+					ft.fail.Store(slices.Contains(tt.fail, name))
+					trackers[name] = ft
+					names[ft.url] = name
+					urls = append(urls, ft.url)
+				}
+				tiers = append(tiers, urls)
+			}
+			a := testAnnouncer(t, tiers)
+
+			before := time.Now()
+			next := a.announceTiers()
+
+			current := ""
+			if a.current != nil {
+				current = names[a.current.status.URL]
+			}
+			if current != tt.current {
+				t.Errorf("tracker atual %q, esperado %q", current, tt.current)
+			}
+			var order [][]string
+			for _, tier := range tierURLs(a) {
+				var list []string
+				for _, u := range tier {
+					list = append(list, names[u])
+				}
+				order = append(order, list)
+			}
+			if fmt.Sprint(order) != fmt.Sprint(tt.order) {
+				t.Errorf("ordem %v, esperada %v", order, tt.order)
+			}
+			for name, ft := range trackers {
+				if got := ft.hits.Load(); got != tt.hits[name] {
+					t.Errorf("%s recebeu %d anúncios, esperados %d", name, got, tt.hits[name])
+				}
+			}
+
+			// Sem resposta, todos voltam a ser tentados depois do intervalo de espera
+			if tt.current == "" && next.Sub(before) < retryAnnounceInterval {
+				t.Errorf("próximo anúncio em %v, esperado %v", next.Sub(before), retryAnnounceInterval)
+			}
+			states := make(map[string]*trackerState)
+			for _, tier := range a.tiers {
+				for _, tr := range tier {
+					states[names[tr.status.URL]] = tr
+				}
+			}
+			for _, name := range tt.fail {
+				tr := states[name]
+				if tr.status.Status != TrackerError || !tr.status.NextAnnounce.Equal(next) {
+					t.Errorf("%s: estado %s, próximo %v, esperado erro e %v", name, tr.status.Status, tr.status.NextAnnounce, next)
+				}
+			}
+		})
+	}
+}
+
+// Um nível anterior que volta a responder passa a ser usado, e o tracker que
+// respondia antes fica em espera
+func TestAnnounceTiersReturnsToEarlierTier(t *testing.T) {
+	first, second := startFakeTracker(t), startFakeTracker(t)
+	first.fail.Store(true)
+	a := testAnnouncer(t, [][]string{{first.url}, {second.url}})
+
+	a.announceTiers()
+	if a.current == nil || a.current.status.URL != second.url {
+		t.Fatal("o segundo nível deveria responder")
+	}
+	fallback := a.current
+
+	first.fail.Store(false)
+	a.announceTiers()
+	if a.current == nil || a.current.status.URL != first.url {
+		t.Fatal("o primeiro nível deveria voltar a ser usado")
+	}
+	if fallback.status.Status != TrackerWaiting || !fallback.status.NextAnnounce.IsZero() {
+		t.Errorf("tracker anterior: estado %s, próximo %v", fallback.status.Status, fallback.status.NextAnnounce)
+	}
+	if got := second.hits.Load(); got != 1 {
+		t.Errorf("segundo nível recebeu %d anúncios, esperado 1", got)
+	}
+
+	// O tracker que responde continua sendo o único a receber anúncios
+	a.announceTiers()
+	if first.hits.Load() != 3 || second.hits.Load() != 1 {
+		t.Errorf("anúncios: primeiro %d, segundo %d", first.hits.Load(), second.hits.Load())
+	}
+}
+
+func TestAnnounceInterval(t *testing.T) {
+	tests := []struct {
+		name     string
+		interval int32
+		fail     bool
+		want     time.Duration
+	}{
+		{name: "intervalo do tracker", interval: 3600, want: time.Hour},
+		{name: "sem intervalo", interval: 0, want: defaultAnnounceInterval},
+		{name: "intervalo abaixo do mínimo", interval: 10, want: minAnnounceInterval},
+		{name: "falha", fail: true, want: retryAnnounceInterval},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ft := startFakeTracker(t)
+			ft.interval.Store(tt.interval)
+			ft.fail.Store(tt.fail)
+			a := testAnnouncer(t, [][]string{{ft.url}})
+			tr := a.tiers[0][0]
+
+			before := time.Now()
+			next, err := a.announce(context.Background(), tr, tracker.None)
+			after := time.Now()
+			if (err != nil) != tt.fail {
+				t.Fatalf("erro %v", err)
+			}
+			if next.Before(before.Add(tt.want)) || next.After(after.Add(tt.want)) {
+				t.Errorf("próximo anúncio em %v, esperado %v", next.Sub(before), tt.want)
+			}
+			if !tr.status.NextAnnounce.Equal(next) {
+				t.Errorf("status com próximo anúncio %v, retornado %v", tr.status.NextAnnounce, next)
+			}
+			if !tt.fail && (tr.status.Seeders != 1 || tr.status.Leechers != 2) {
+				t.Errorf("seeders %d, leechers %d", tr.status.Seeders, tr.status.Leechers)
+			}
+		})
+	}
+}
+
+func TestAnnounceUsesRequestDirector(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Teste") != "sim" {
+			http.Error(w, "sem cabeçalho", http.StatusForbidden)
+			return
+		}
+		io.WriteString(w, "d8:intervali1800e5:peers0:e")
+	}))
+	defer srv.Close()
+
+	cfg := torrent.NewDefaultClientConfig()
+	cfg.Logger = log.Default
+	cfg.HttpRequestDirector = func(r *http.Request) error {
+		r.Header.Set("X-Teste", "sim")
+		return nil
+	}
+	if _, err := announceThrough(cfg, srv.URL+"/announce"); err != nil {
+		t.Fatalf("anúncio sem HttpRequestDirector: %v", err)
+	}
+}
+
+// O cliente não deve anunciar aos trackers HTTP e UDP, que ficam com o
+// trackerSet. Se a biblioteca deixar de resolver o endereço antes de anunciar
+// ou de chamar LookupTrackerIp, este teste falha e skipTrackerLookup precisa
+// ser revisto.
+func TestClientSkipsTrackerAnnounces(t *testing.T) {
+	ft := startFakeTracker(t)
+
+	cfg := torrent.TestingConfig(t)
+	cfg.DisableTrackers = false
+	lookups := make(chan struct{}, 1)
+	cfg.LookupTrackerIp = func(u *url.URL) ([]net.IP, error) {
+		select {
+		case lookups <- struct{}{}:
+		default:
+		}
+		return skipTrackerLookup(u)
+	}
+	cl, err := torrent.NewClient(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cl.Close()
+
+	_, _, err = cl.AddTorrentSpec(&torrent.TorrentSpec{
+		InfoHash: metainfo.Hash{2},
+		Trackers: [][]string{{ft.url}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case <-lookups:
+	case <-time.After(10 * time.Second):
+		t.Fatal("o cliente não chamou LookupTrackerIp")
+	}
+	time.Sleep(500 * time.Millisecond)
+	if got := ft.hits.Load(); got != 0 {
+		t.Fatalf("o cliente anunciou %d vezes ao tracker HTTP", got)
+	}
+}
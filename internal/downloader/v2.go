@@ -0,0 +1,96 @@
+package downloader
+
+import (
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/anacrolix/torrent"
+	"github.com/anacrolix/torrent/metainfo"
+	infohash_v2 "github.com/anacrolix/torrent/types/infohash-v2"
+)
+
+// v2ReconnectDelay é a espera entre fechar e refazer as conexões de um magnet só v2
+const v2ReconnectDelay = time.Second
+
+// Versões do protocolo de um torrent (BEP 52)
+const (
+	VersionV1     = "v1"
+	VersionV2     = "v2"
+	VersionHybrid = "hybrid"
+)
+
+// infoVersion retorna a versão do protocolo descrita pelo info dict
+func infoVersion(info *metainfo.Info) string {
+	switch {
+	case info.HasV1() && info.HasV2():
+		return VersionHybrid
+	case info.HasV2():
+		return VersionV2
+	default:
+		return VersionV1
+	}
+}
+
+// infoHashV2 retorna o info hash v2 de um torrent v2 ou híbrido cujos
+// metadados já são conhecidos
+func infoHashV2(t *torrent.Torrent) (infohash_v2.T, bool) {
+	info := t.Info()
+	if info == nil || !info.HasV2() {
+		return infohash_v2.T{}, false
+	}
+	return infohash_v2.HashBytes(t.Metainfo().InfoBytes), true
+}
+
+// isV2Only indica se o torrent só tem o info hash v2
+func isV2Only(spec *torrent.TorrentSpec) bool {
+	return spec.InfoHash == (metainfo.Hash{}) && spec.InfoHashV2.Ok
+}
+
+// addSpec adiciona um torrent ao cliente. O cliente indexa os torrents pelo
+// info hash v1, que os torrents só v2 não têm, e não os encontraria nas conexões
+// recebidas; eles entram pelo info hash v2 truncado, que o cliente reconhece e
+// completa com o v2 inteiro ao ler o info dict.
+func (e *engine) addSpec(spec *torrent.TorrentSpec) (*torrent.Torrent, error) {
+	if isV2Only(spec) {
+		spec.InfoHash = *spec.InfoHashV2.Value.ToShort()
+		spec.InfoHashV2.SetNone()
+	}
+	t, _, err := e.client.AddTorrentSpec(spec)
+	return t, err
+}
+
+// reconnectV2 refaz as conexões de um magnet só v2 abertas antes dos metadados.
+// Sem saber que o torrent é v2, o cliente as trata como v1 e não pede por elas
+// as camadas de hashes que verificam as peças.
+func reconnectV2(t *torrent.Torrent) {
+	select {
+	case <-t.GotInfo():
+	case <-t.Closed():
+		return
+	}
+
+	var peers []torrent.PeerInfo
+	for _, pc := range t.PeerConns() {
+		addr := pc.RemoteAddr
+		// Em conexões recebidas, a porta de origem não aceita conexões
+		if host, _, err := net.SplitHostPort(addr.String()); err == nil && pc.PeerListenPort != 0 {
+			if tcp, err := net.ResolveTCPAddr("tcp", net.JoinHostPort(host, strconv.Itoa(pc.PeerListenPort))); err == nil {
+				addr = tcp
+			}
+		}
+		pc.Close()
+		peers = append(peers, torrent.PeerInfo{Addr: addr, Source: pc.Discovery})
+	}
+	if len(peers) == 0 {
+		return
+	}
+
+	// O peer recusa a nova conexão enquanto ainda considera a antiga aberta
+	select {
+	case <-time.After(v2ReconnectDelay):
+	case <-t.Closed():
+		return
+	}
+	t.AddPeers(peers)
+}
@@ -0,0 +1,63 @@
+package downloader
+
+import (
+	"fmt"
+	"net/url"
+	"sync"
+
+	"github.com/anacrolix/torrent"
+)
+
+// webseedNetwork é como o cliente identifica os peers que são web seeds (BEP 19)
+const webseedNetwork = "http"
+
+// validateWebSeeds verifica se as URLs de web seeds são HTTP(S)
+func validateWebSeeds(urls []string) error {
+	for _, raw := range urls {
+		u, err := url.Parse(raw)
+		if err != nil || u.Host == "" || (u.Scheme != "http" && u.Scheme != "https") {
+			return fmt.Errorf("web seed inválido %s: use uma URL http ou https", raw)
+		}
+	}
+	return nil
+}
+
+// webseedCounter soma os dados úteis recebidos de web seeds em cada torrent,
+// que o cliente conta junto com os recebidos dos peers
+type webseedCounter struct {
+	mu    sync.Mutex
+	bytes map[*torrent.Torrent]int64
+}
+
+func newWebseedCounter() *webseedCounter {
+	return &webseedCounter{bytes: make(map[*torrent.Torrent]int64)}
+}
+
+// install registra a contagem nos callbacks do cliente
+func (wc *webseedCounter) install(cb *torrent.Callbacks) {
+	cb.ReceivedUsefulData = append(cb.ReceivedUsefulData, wc.received)
+}
+
+// received é chamado com o cliente travado, então não pode chamar métodos dele
+func (wc *webseedCounter) received(ev torrent.ReceivedUsefulDataEvent) {
+	if ev.Peer.Network != webseedNetwork {
+		return
+	}
+	wc.mu.Lock()
+	wc.bytes[ev.Peer.Torrent()] += int64(len(ev.Message.Piece))
+	wc.mu.Unlock()
+}
+
+// read retorna quantos bytes úteis o torrent já recebeu de web seeds
+func (wc *webseedCounter) read(t *torrent.Torrent) int64 {
+	wc.mu.Lock()
+	defer wc.mu.Unlock()
+	return wc.bytes[t]
+}
+
+// forget descarta a contagem de um torrent retirado do cliente
+func (wc *webseedCounter) forget(t *torrent.Torrent) {
+	wc.mu.Lock()
+	delete(wc.bytes, t)
+	wc.mu.Unlock()
+}
@@ -0,0 +1,150 @@
+// Package history guarda um registro dos downloads concluídos, que falharam
+// ou foram cancelados, para consultá-los e baixá-los novamente.
+package history
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Result é como o download terminou
+type Result string
+
+// Resultados registrados
+const (
+	ResultCompleted Result = "completed"
+	ResultFailed    Result = "failed"
+	ResultCanceled  Result = "canceled"
+)
+
+// Entry descreve um download que terminou
+type Entry struct {
+	// ID é o número da linha da entrada no arquivo, atribuído ao lê-lo
+	ID       int    `json:"-"`
+	Name     string `json:"name"`
+	InfoHash string `json:"info_hash,omitempty"`
+	// Link é o magnet ou arquivo .torrent de origem; Magnet permite baixar o
+	// torrent de novo quando o arquivo não existe mais
+	Link     string    `json:"link,omitempty"`
+	Magnet   string    `json:"magnet,omitempty"`
+	Category string    `json:"category,omitempty"`
+	Size     int64     `json:"size"`
+	Path     string    `json:"path,omitempty"`
+	Started  time.Time `json:"started"`
+	Finished time.Time `json:"finished"`
+	// AverageSpeed é a média, em bytes por segundo, do que foi baixado da rede
+	AverageSpeed float64 `json:"average_speed"`
+	Result       Result  `json:"result"`
+	Error        string  `json:"error,omitempty"`
+}
+
+// Source retorna o link para baixar a entrada novamente
+func (e Entry) Source() string {
+	if strings.HasPrefix(e.Link, "magnet:") || e.Magnet == "" {
+		return e.Link
+	}
+	if _, err := os.Stat(e.Link); err == nil {
+		return e.Link
+	}
+	return e.Magnet
+}
+
+// Matches indica se a entrada contém o termo no nome, info hash ou link,
+// sem diferenciar maiúsculas de minúsculas
+func (e Entry) Matches(term string) bool {
+	term = strings.ToLower(term)
+	for _, field := range []string{e.Name, e.InfoHash, e.Link, e.Category} {
+		if strings.Contains(strings.ToLower(field), term) {
+			return true
+		}
+	}
+	return false
+}
+
+// Store é o histórico guardado em um arquivo JSON Lines, uma entrada por linha.
+// As entradas só são acrescentadas, então o daemon e downloads avulsos podem
+// gravar no mesmo arquivo.
+type Store struct {
+	path string
+	mu   sync.Mutex
+}
+
+// New cria um histórico guardado em path
+func New(path string) *Store {
+	return &Store{path: path}
+}
+
+// Add acrescenta uma entrada ao histórico
+func (s *Store) Add(e Entry) error {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := os.MkdirAll(filepath.Dir(s.path), 0755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(s.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	// Uma única escrita por linha evita misturar entradas de processos diferentes
+	if _, err := f.Write(append(data, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// List retorna as entradas, da mais antiga para a mais recente. Linhas que
+// não puderem ser lidas, como uma escrita interrompida, são ignoradas.
+func (s *Store) List() ([]Entry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	f, err := os.Open(s.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var entries []Entry
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 0, 64<<10), 1<<20)
+	for line := 1; scanner.Scan(); line++ {
+		var e Entry
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			continue
+		}
+		e.ID = line
+		entries = append(entries, e)
+	}
+	return entries, scanner.Err()
+}
+
+// Get retorna a entrada com o ID informado
+func (s *Store) Get(id int) (Entry, error) {
+	entries, err := s.List()
+	if err != nil {
+		return Entry{}, err
+	}
+	for _, e := range entries {
+		if e.ID == id {
+			return e, nil
+		}
+	}
+	return Entry{}, fmt.Errorf("entrada %d não encontrada no histórico", id)
+}
@@ -0,0 +1,151 @@
+// Package hooks executa comandos e webhooks configurados quando um
+// download termina, falha ou é cancelado.
+package hooks
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"os"
+	"os/exec"
+	"runtime"
+	"strconv"
+	"time"
+
+	"github.com/alucod3/gorrent/internal/config"
+)
+
+// defaultTimeout limita hooks que não definem o próprio tempo limite
+const defaultTimeout = 30 * time.Second
+
+// Event identifica o resultado do download que disparou os hooks
+type Event string
+
+// Eventos suportados
+const (
+	EventComplete Event = "complete"
+	EventFailure  Event = "failure"
+	EventCancel   Event = "cancel"
+)
+
+// Payload descreve o download; é enviado aos webhooks e exposto aos comandos
+type Payload struct {
+	Event    Event  `json:"event"`
+	Name     string `json:"name"`
+	Path     string `json:"path"`
+	InfoHash string `json:"infohash"`
+	Size     int64  `json:"size"`
+	Category string `json:"category,omitempty"`
+	Error    string `json:"error,omitempty"`
+}
+
+// Runner executa os hooks configurados para cada evento
+type Runner struct {
+	config *config.Config
+	logger *log.Logger
+}
+
+// NewRunner cria um executor de hooks que registra a saída em logger
+func NewRunner(cfg *config.Config, logger *log.Logger) *Runner {
+	return &Runner{
+		config: cfg,
+		logger: logger,
+	}
+}
+
+// Run executa, em ordem, todos os hooks configurados para o evento do payload
+func (r *Runner) Run(p Payload) {
+	if r == nil {
+		return
+	}
+
+	hooks := hooksFor(r.config.Hooks, p.Event)
+	if category := r.config.Category(p.Category); category != nil {
+		hooks = append(hooks, hooksFor(category.Hooks, p.Event)...)
+	}
+
+	for _, hook := range hooks {
+		timeout := hook.Timeout.Duration
+		if timeout <= 0 {
+			timeout = defaultTimeout
+		}
+
+		if hook.Command != "" {
+			r.runCommand(hook.Command, timeout, p)
+		}
+		if hook.WebhookURL != "" {
+			r.postWebhook(hook.WebhookURL, timeout, p)
+		}
+	}
+}
+
+// hooksFor retorna uma cópia dos hooks configurados para um evento
+func hooksFor(hooks config.Hooks, event Event) []config.Hook {
+	var list []config.Hook
+	switch event {
+	case EventComplete:
+		list = hooks.OnComplete
+	case EventFailure:
+		list = hooks.OnFailure
+	case EventCancel:
+		list = hooks.OnCancel
+	}
+	return append([]config.Hook(nil), list...)
+}
+
+// runCommand executa o comando pelo shell do sistema com as variáveis GORRENT_*
+func (r *Runner) runCommand(command string, timeout time.Duration, p Payload) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	var cmd *exec.Cmd
+	if runtime.GOOS == "windows" {
+		cmd = exec.CommandContext(ctx, "cmd", "/c", command)
+	} else {
+		cmd = exec.CommandContext(ctx, "sh", "-c", command)
+	}
+	cmd.Env = append(os.Environ(),
+		"GORRENT_EVENT="+string(p.Event),
+		"GORRENT_NAME="+p.Name,
+		"GORRENT_PATH="+p.Path,
+		"GORRENT_INFOHASH="+p.InfoHash,
+		"GORRENT_SIZE="+strconv.FormatInt(p.Size, 10),
+		"GORRENT_CATEGORY="+p.Category,
+		"GORRENT_ERROR="+p.Error,
+	)
+
+	output, err := cmd.CombinedOutput()
+	if len(output) > 0 {
+		r.logger.Printf("hook %s [%s]: %s", p.Event, command, bytes.TrimSpace(output))
+	}
+	if ctx.Err() == context.DeadlineExceeded {
+		r.logger.Printf("hook %s [%s]: tempo limite de %s excedido", p.Event, command, timeout)
+	} else if err != nil {
+		r.logger.Printf("hook %s [%s]: %v", p.Event, command, err)
+	}
+}
+
+// postWebhook envia o payload em JSON para a URL do webhook
+func (r *Runner) postWebhook(url string, timeout time.Duration, p Payload) {
+	body, err := json.Marshal(p)
+	if err != nil {
+		r.logger.Printf("webhook %s [%s]: %v", p.Event, url, err)
+		return
+	}
+
+	client := &http.Client{Timeout: timeout}
+	resp, err := client.Post(url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		r.logger.Printf("webhook %s [%s]: %v", p.Event, url, err)
+		return
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		r.logger.Printf("webhook %s [%s]: resposta inesperada %s", p.Event, url, resp.Status)
+		return
+	}
+	r.logger.Printf("webhook %s [%s]: %s", p.Event, url, resp.Status)
+}
@@ -60,6 +60,9 @@ func (v *Validator) IsValidTorrentLink(link string) error {
 
 	// Verifica se é uma URL válida
 	if u, err := url.Parse(link); err == nil && u.Scheme != "" && u.Host != "" {
+		if u.Scheme != "http" && u.Scheme != "https" {
+			return fmt.Errorf("URL deve usar http ou https")
+		}
 		if strings.HasSuffix(strings.ToLower(u.Path), v.config.TorrentExtension) {
 			return nil
 		}
@@ -0,0 +1,180 @@
+// Package watcher monitora pastas em busca de novos arquivos .torrent
+// e os envia para download.
+package watcher
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/alucod3/gorrent/internal/config"
+	"github.com/alucod3/gorrent/internal/validator"
+)
+
+// Subpastas para onde os arquivos processados são movidos
+const (
+	DoneDir   = "done"
+	FailedDir = "failed"
+)
+
+// IngestFunc enfileira o arquivo .torrent no caminho informado para download;
+// o arquivo já está em done/ e continua lá enquanto o download existir
+type IngestFunc func(path string) error
+
+// fileState guarda o último tamanho e data de modificação vistos de um arquivo
+type fileState struct {
+	size    int64
+	modTime time.Time
+}
+
+// Watcher verifica periodicamente as pastas configuradas
+type Watcher struct {
+	config    *config.Config
+	validator *validator.Validator
+	ingest    IngestFunc
+	logger    *log.Logger
+	pending   map[string]fileState
+}
+
+// New cria um monitor para as pastas em cfg.WatchDirs
+func New(cfg *config.Config, ingest IngestFunc, logger *log.Logger) *Watcher {
+	return &Watcher{
+		config:    cfg,
+		validator: validator.WithConfig(cfg),
+		ingest:    ingest,
+		logger:    logger,
+		pending:   make(map[string]fileState),
+	}
+}
+
+// Run verifica as pastas até o contexto ser cancelado
+func (w *Watcher) Run(ctx context.Context) error {
+	if w.config.WatchInterval.Duration <= 0 {
+		return fmt.Errorf("intervalo de verificação inválido: %s", w.config.WatchInterval.Duration)
+	}
+	for _, dir := range w.config.WatchDirs {
+		for _, sub := range []string{DoneDir, FailedDir} {
+			if err := os.MkdirAll(filepath.Join(dir, sub), 0755); err != nil {
+				return fmt.Errorf("erro ao preparar pasta monitorada: %w", err)
+			}
+		}
+	}
+
+	ticker := time.NewTicker(w.config.WatchInterval.Duration)
+	defer ticker.Stop()
+
+	for {
+		for _, dir := range w.config.WatchDirs {
+			w.scan(dir)
+		}
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+// scan processa os arquivos de uma pasta que pararam de ser escritos
+func (w *Watcher) scan(dir string) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		w.logger.Printf("watch: erro ao ler %s: %v", dir, err)
+		return
+	}
+
+	for _, entry := range entries {
+		if entry.IsDir() || strings.HasPrefix(entry.Name(), ".") {
+			continue
+		}
+
+		path := filepath.Join(dir, entry.Name())
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+
+		// Só processa o arquivo quando ele não mudou desde a última verificação,
+		// evitando ler arquivos que ainda estão sendo copiados
+		current := fileState{size: info.Size(), modTime: info.ModTime()}
+		if previous, ok := w.pending[path]; !ok || previous != current {
+			w.pending[path] = current
+			continue
+		}
+
+		delete(w.pending, path)
+		w.process(dir, path)
+	}
+}
+
+// process valida e enfileira um arquivo, movendo-o para done/ ou failed/. Ele
+// vai para done/ antes de ser enfileirado, para que o histórico e a sessão
+// guardem o caminho onde o arquivo continua existindo.
+func (w *Watcher) process(dir, path string) {
+	if err := w.validator.IsValidTorrentLink(path); err != nil {
+		w.fail(dir, path, err)
+		return
+	}
+
+	target, err := moveUnique(path, filepath.Join(dir, DoneDir))
+	if err != nil {
+		w.logger.Printf("watch: erro ao mover %s: %v", path, err)
+		return
+	}
+	if err := w.ingest(target); err != nil {
+		w.fail(dir, target, err)
+		return
+	}
+	w.logger.Printf("watch: %s adicionado", target)
+}
+
+// fail registra a falha e move o arquivo para failed/
+func (w *Watcher) fail(dir, path string, reason error) {
+	w.logger.Printf("watch: %s falhou: %v", path, reason)
+	if err := moveWithReason(path, filepath.Join(dir, FailedDir), reason); err != nil {
+		w.logger.Printf("watch: erro ao mover %s: %v", path, err)
+	}
+}
+
+// moveWithReason move o arquivo para dest e grava o motivo da falha ao lado dele
+func moveWithReason(path, dest string, reason error) error {
+	target, err := moveUnique(path, dest)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(target+".reason.txt", []byte(reason.Error()+"\n"), 0644)
+}
+
+// moveUnique move o arquivo para dest sem sobrescrever arquivos existentes,
+// acrescentando um número ao nome quando ele já está em uso. O link falha se o
+// destino existir, o que evita sobrescrever um arquivo criado depois da
+// verificação; sem suporte a links, o destino é conferido antes de renomear.
+func moveUnique(path, dest string) (string, error) {
+	name := filepath.Base(path)
+	ext := filepath.Ext(name)
+	for n := 0; ; n++ {
+		target := filepath.Join(dest, name)
+		if n > 0 {
+			target = filepath.Join(dest, fmt.Sprintf("%s-%d%s", strings.TrimSuffix(name, ext), n, ext))
+		}
+
+		err := os.Link(path, target)
+		if err == nil {
+			return target, os.Remove(path)
+		}
+		if errors.Is(err, fs.ErrExist) {
+			continue
+		}
+		if _, err := os.Lstat(target); err == nil {
+			continue
+		}
+		return target, os.Rename(path, target)
+	}
+}
@@ -0,0 +1,96 @@
+package watcher
+
+import (
+	"errors"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/alucod3/gorrent/internal/config"
+)
+
+func TestMoveUnique(t *testing.T) {
+	src, dest := t.TempDir(), t.TempDir()
+	for _, name := range []string{"a.torrent", "a-1.torrent"} {
+		if err := os.WriteFile(filepath.Join(dest, name), []byte("antigo"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for i, want := range []string{"a-2.torrent", "a-3.torrent"} {
+		path := filepath.Join(src, "a.torrent")
+		if err := os.WriteFile(path, []byte{byte('0' + i)}, 0644); err != nil {
+			t.Fatal(err)
+		}
+		target, err := moveUnique(path, dest)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if target != filepath.Join(dest, want) {
+			t.Errorf("movido para %s, esperado %s", target, want)
+		}
+		if data, err := os.ReadFile(target); err != nil || data[0] != byte('0'+i) {
+			t.Errorf("%s: conteúdo %q, %v", want, data, err)
+		}
+		if _, err := os.Stat(path); !os.IsNotExist(err) {
+			t.Errorf("%s continua na origem", path)
+		}
+	}
+
+	for _, name := range []string{"a.torrent", "a-1.torrent"} {
+		if data, _ := os.ReadFile(filepath.Join(dest, name)); string(data) != "antigo" {
+			t.Errorf("%s foi sobrescrito: %q", name, data)
+		}
+	}
+}
+
+func TestProcess(t *testing.T) {
+	tests := []struct {
+		name   string
+		file   string
+		ingest error
+		sub    string
+	}{
+		{name: "adicionado", file: "ok.torrent", sub: DoneDir},
+		{name: "recusado", file: "ruim.torrent", ingest: errors.New("torrent inválido"), sub: FailedDir},
+		{name: "extensão errada", file: "nota.txt", sub: FailedDir},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			for _, sub := range []string{DoneDir, FailedDir} {
+				os.Mkdir(filepath.Join(dir, sub), 0755)
+			}
+			path := filepath.Join(dir, tt.file)
+			if err := os.WriteFile(path, []byte("d4:infode"), 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			var ingested string
+			cfg := &config.Config{MagnetPattern: "^magnet:", TorrentExtension: ".torrent"}
+			w := New(cfg, func(p string) error {
+				// O caminho enfileirado precisa existir, pois é o que fica registrado
+				if _, err := os.Stat(p); err != nil {
+					t.Errorf("enfileirado %s, que não existe: %v", p, err)
+				}
+				ingested = p
+				return tt.ingest
+			}, log.New(io.Discard, "", 0))
+			w.process(dir, path)
+
+			want := filepath.Join(dir, tt.sub, tt.file)
+			if _, err := os.Stat(want); err != nil {
+				t.Errorf("arquivo não foi para %s: %v", tt.sub, err)
+			}
+			if tt.file == "ok.torrent" && ingested != want {
+				t.Errorf("enfileirado %q, esperado %q", ingested, want)
+			}
+			if _, err := os.Stat(want + ".reason.txt"); (err == nil) != (tt.sub == FailedDir) {
+				t.Errorf("motivo da falha: %v", err)
+			}
+		})
+	}
+}
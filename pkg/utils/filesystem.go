@@ -1,8 +1,13 @@
 package utils
 
 import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
 	"os"
 	"path/filepath"
+	"syscall"
 )
 
 // EnsureDirectoryExists garante que um diretório existe, criando-o se necessário
@@ -35,3 +40,91 @@ func DirectoryExists(path string) bool {
 	}
 	return info.IsDir()
 }
+
+// rename é trocado nos testes para simular destinos em outro sistema de arquivos
+var rename = os.Rename
+
+// MovePath move um arquivo ou diretório para dst. Dentro do mesmo sistema de
+// arquivos a operação é atômica; entre sistemas diferentes o conteúdo é copiado
+// para um caminho temporário, renomeado para dst e só então removido da origem.
+// Um dst já existente não é sobrescrito nem mesclado.
+func MovePath(src, dst string) error {
+	if err := EnsureDirectoryExists(filepath.Dir(dst)); err != nil {
+		return err
+	}
+	if _, err := os.Lstat(dst); err == nil {
+		return fmt.Errorf("%s já existe", dst)
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+
+	err := rename(src, dst)
+	if err == nil || !errors.Is(err, syscall.EXDEV) {
+		return err
+	}
+
+	tmp := dst + ".partial"
+	if err := copyTree(src, tmp); err != nil {
+		os.RemoveAll(tmp)
+		return err
+	}
+	if err := rename(tmp, dst); err != nil {
+		os.RemoveAll(tmp)
+		return err
+	}
+	return os.RemoveAll(src)
+}
+
+// copyTree copia recursivamente um arquivo ou diretório, preservando as
+// permissões. Links simbólicos são recriados apontando para o mesmo alvo, em
+// vez de copiar o que apontam, e outros arquivos especiais são recusados.
+func copyTree(src, dst string) error {
+	return filepath.WalkDir(src, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		switch {
+		case entry.IsDir():
+			return os.MkdirAll(target, info.Mode().Perm())
+		case entry.Type()&fs.ModeSymlink != 0:
+			link, err := os.Readlink(path)
+			if err != nil {
+				return err
+			}
+			return os.Symlink(link, target)
+		case !entry.Type().IsRegular():
+			return fmt.Errorf("%s não é um arquivo comum e não pode ser copiado", path)
+		}
+		return copyFile(path, target, info.Mode().Perm())
+	})
+}
+
+// copyFile copia o conteúdo de um arquivo regular
+func copyFile(src, dst string, perm fs.FileMode) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, perm)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
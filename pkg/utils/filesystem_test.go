@@ -0,0 +1,140 @@
+package utils
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+)
+
+// crossDevice faz o rename de src falhar como se dst estivesse em outro
+// sistema de arquivos; os demais usam o rename real
+func crossDevice(t *testing.T, src string) {
+	t.Helper()
+	rename = func(oldpath, newpath string) error {
+		if oldpath == src {
+			return &os.LinkError{Op: "rename", Old: oldpath, New: newpath, Err: syscall.EXDEV}
+		}
+		return os.Rename(oldpath, newpath)
+	}
+	t.Cleanup(func() { rename = os.Rename })
+}
+
+// writeTree cria um diretório com arquivos, uma subpasta e um link simbólico
+func writeTree(t *testing.T, dir string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Join(dir, "sub"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	for name, data := range map[string]string{"a.txt": "a", "sub/b.txt": "b"} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(data), 0640); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Symlink("sub/b.txt", filepath.Join(dir, "link")); err != nil {
+		t.Skip("links simbólicos indisponíveis:", err)
+	}
+}
+
+func TestMovePathExistingDestination(t *testing.T) {
+	tmp := t.TempDir()
+	src, dst := filepath.Join(tmp, "src"), filepath.Join(tmp, "dst")
+	writeTree(t, src)
+	if err := os.WriteFile(dst, []byte("antigo"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := MovePath(src, dst); err == nil {
+		t.Fatal("dst existente foi sobrescrito")
+	}
+	if data, _ := os.ReadFile(dst); string(data) != "antigo" {
+		t.Errorf("dst alterado: %q", data)
+	}
+	if !FileExists(filepath.Join(src, "a.txt")) {
+		t.Error("origem alterada")
+	}
+}
+
+func TestMovePathCrossDevice(t *testing.T) {
+	tmp := t.TempDir()
+	src, dst := filepath.Join(tmp, "src"), filepath.Join(tmp, "out", "dst")
+	writeTree(t, src)
+	crossDevice(t, src)
+
+	if err := MovePath(src, dst); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Lstat(src); !os.IsNotExist(err) {
+		t.Errorf("origem não foi removida: %v", err)
+	}
+	if _, err := os.Lstat(dst + ".partial"); !os.IsNotExist(err) {
+		t.Errorf("cópia temporária ficou para trás: %v", err)
+	}
+
+	for name, want := range map[string]string{"a.txt": "a", "sub/b.txt": "b"} {
+		path := filepath.Join(dst, name)
+		if data, err := os.ReadFile(path); err != nil || string(data) != want {
+			t.Errorf("%s: %q, %v", name, data, err)
+		}
+		if info, err := os.Stat(path); err == nil && info.Mode().Perm() != 0640 {
+			t.Errorf("%s: permissões %v", name, info.Mode().Perm())
+		}
+	}
+	if link, err := os.Readlink(filepath.Join(dst, "link")); err != nil || link != "sub/b.txt" {
+		t.Errorf("link simbólico não recriado: %q, %v", link, err)
+	}
+}
+
+// Um link simbólico movido sozinho continua sendo um link, e não uma cópia do alvo
+func TestMovePathCrossDeviceSymlink(t *testing.T) {
+	tmp := t.TempDir()
+	target := filepath.Join(tmp, "alvo.txt")
+	if err := os.WriteFile(target, []byte("alvo"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	src, dst := filepath.Join(tmp, "link"), filepath.Join(tmp, "out", "link")
+	if err := os.Symlink(target, src); err != nil {
+		t.Skip("links simbólicos indisponíveis:", err)
+	}
+	crossDevice(t, src)
+
+	if err := MovePath(src, dst); err != nil {
+		t.Fatal(err)
+	}
+	if link, err := os.Readlink(dst); err != nil || link != target {
+		t.Errorf("destino %q, %v; esperado link para %s", link, err, target)
+	}
+	if data, _ := os.ReadFile(target); string(data) != "alvo" {
+		t.Errorf("alvo alterado: %q", data)
+	}
+}
+
+func TestMovePathCrossDeviceFailure(t *testing.T) {
+	tmp := t.TempDir()
+	src, dst := filepath.Join(tmp, "src"), filepath.Join(tmp, "dst")
+	writeTree(t, src)
+
+	// A cópia termina, mas não pode ser renomeada para dst
+	failed := errors.New("disco cheio")
+	t.Cleanup(func() { rename = os.Rename })
+	rename = func(oldpath, newpath string) error {
+		if oldpath == src {
+			return &os.LinkError{Op: "rename", Old: oldpath, New: newpath, Err: syscall.EXDEV}
+		}
+		return failed
+	}
+
+	if err := MovePath(src, dst); !errors.Is(err, failed) {
+		t.Fatalf("erro %v, esperado %v", err, failed)
+	}
+	if _, err := os.Lstat(dst + ".partial"); !os.IsNotExist(err) {
+		t.Errorf("cópia temporária ficou para trás: %v", err)
+	}
+	if _, err := os.Lstat(dst); !os.IsNotExist(err) {
+		t.Errorf("dst criado: %v", err)
+	}
+	if !FileExists(filepath.Join(src, "sub", "b.txt")) {
+		t.Error("origem removida depois da falha")
+	}
+}
@@ -0,0 +1,31 @@
+//go:build unix
+
+package utils
+
+import (
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+)
+
+// Um arquivo especial na origem interrompe a cópia, que é desfeita
+func TestMovePathCrossDeviceSpecialFile(t *testing.T) {
+	tmp := t.TempDir()
+	src, dst := filepath.Join(tmp, "src"), filepath.Join(tmp, "dst")
+	writeTree(t, src)
+	if err := syscall.Mkfifo(filepath.Join(src, "sub", "fifo"), 0644); err != nil {
+		t.Skip("fifo indisponível:", err)
+	}
+	crossDevice(t, src)
+
+	if err := MovePath(src, dst); err == nil {
+		t.Fatal("fifo copiado")
+	}
+	if _, err := os.Lstat(dst + ".partial"); !os.IsNotExist(err) {
+		t.Errorf("cópia temporária ficou para trás: %v", err)
+	}
+	if !FileExists(filepath.Join(src, "a.txt")) {
+		t.Error("origem removida depois da falha")
+	}
+}